@@ -0,0 +1,119 @@
+package main
+
+import "math"
+
+// aiProfile tunes the AI for one difficulty. speed is a fraction of the
+// match's paddleSpeed, so the AI never outruns a human paddle.
+type aiProfile struct {
+	speed           float64
+	reactionTime    float64
+	predictionError float64
+	deadZone        float64
+	returnToCenter  bool
+}
+
+var aiProfiles = map[string]aiProfile{
+	"easy": {
+		speed:           0.5,
+		reactionTime:    0.35,
+		predictionError: 70,
+		deadZone:        15,
+		returnToCenter:  false,
+	},
+	"medium": {
+		speed:           0.75,
+		reactionTime:    0.18,
+		predictionError: 35,
+		deadZone:        8,
+		returnToCenter:  true,
+	},
+	"hard": {
+		speed:           1,
+		reactionTime:    0.06,
+		predictionError: 10,
+		deadZone:        4,
+		returnToCenter:  true,
+	},
+}
+
+type aiController struct {
+	difficulty  string
+	targetY     float64
+	thinkTimer  float64
+	errorOffset float64
+	approaching bool
+}
+
+func (c *aiController) Spec() string {
+	return controllerAI + ":" + c.difficulty
+}
+
+func (c *aiController) Reset() {
+	*c = aiController{difficulty: c.difficulty}
+}
+
+func (c *aiController) Update(g *GameState, side string, dt float64) {
+	profile := aiProfiles[c.difficulty]
+	paddle := g.paddle(side)
+
+	interceptX := g.Physics.TableWidth - paddle.Width - g.Ball.Radius
+	approaching := g.Ball.Vel.X > 0
+	if side == "left" {
+		interceptX = paddle.Width + g.Ball.Radius
+		approaching = g.Ball.Vel.X < 0
+	}
+
+	if approaching && !c.approaching {
+		c.errorOffset = (g.rng.Float64()*2 - 1) * profile.predictionError
+	}
+	c.approaching = approaching
+
+	c.thinkTimer -= dt
+	if c.thinkTimer <= 0 {
+		c.thinkTimer = profile.reactionTime
+		switch {
+		case approaching:
+			c.targetY = predictBallY(g.Ball, interceptX, g.Physics.TableHeight) + c.errorOffset
+		case profile.returnToCenter:
+			c.targetY = g.Physics.TableHeight / 2
+		default:
+			c.targetY = paddle.Y + paddle.Height/2
+		}
+	}
+
+	offset := c.targetY - (paddle.Y + paddle.Height/2)
+	if math.Abs(offset) <= profile.deadZone {
+		return
+	}
+	move := math.Min(math.Abs(offset), profile.speed*g.Physics.PaddleSpeed*dt)
+	if offset < 0 {
+		move = -move
+	}
+	paddle.Y = math.Max(0, math.Min(g.Physics.TableHeight-paddle.Height, paddle.Y+move))
+}
+
+// predictBallY returns the height at which the ball will cross x, folding its
+// straight-line path back into a table of the given height to account for
+// wall bounces.
+func predictBallY(ball Ball, x, height float64) float64 {
+	if ball.Vel.X == 0 {
+		return ball.Pos.Y
+	}
+	t := (x - ball.Pos.X) / ball.Vel.X
+	if t < 0 {
+		return ball.Pos.Y
+	}
+
+	span := height - 2*ball.Radius
+	if span <= 0 {
+		return height / 2
+	}
+	y := math.Mod(ball.Pos.Y+ball.Vel.Y*t-ball.Radius, 2*span)
+	if y < 0 {
+		y += 2 * span
+	}
+	if y > span {
+		y = 2*span - y
+	}
+	return y + ball.Radius
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const botTokenLength = 16
+
+// botController steers its paddle with commands from an external program
+// connected to /bot. Each tick the bot is sent an observation and must answer
+// before the observation's deadline; a late or missing answer means no move.
+type botController struct {
+	token     string
+	connected bool
+	seq       uint64
+	deadline  time.Time
+	command   string
+}
+
+func (c *botController) Spec() string {
+	return controllerBot
+}
+
+func (c *botController) Update(g *GameState, side string, dt float64) {
+	g.stepPaddle(g.paddle(side), c.command, dt)
+}
+
+func (c *botController) Reset() {}
+
+type botObservation struct {
+	Type        string  `json:"type"`
+	Seq         uint64  `json:"seq"`
+	Side        string  `json:"side"`
+	DeadlineMs  int64   `json:"deadlineMs"`
+	TableWidth  float64 `json:"tableWidth"`
+	TableHeight float64 `json:"tableHeight"`
+	Ball        Ball    `json:"ball"`
+	LeftPaddle  Paddle  `json:"leftPaddle"`
+	RightPaddle Paddle  `json:"rightPaddle"`
+	LeftScore   int     `json:"leftScore"`
+	RightScore  int     `json:"rightScore"`
+	LeftGames   int     `json:"leftGames"`
+	RightGames  int     `json:"rightGames"`
+	Paused      bool    `json:"paused"`
+	GameOver    bool    `json:"gameOver"`
+}
+
+type botCommand struct {
+	Seq     uint64 `json:"seq"`
+	Command string `json:"command"`
+}
+
+func botDeadline() time.Duration {
+	return time.Second / time.Duration(tickRate)
+}
+
+func botTokens(left, right Controller) map[string]string {
+	tokens := make(map[string]string)
+	if bot, ok := left.(*botController); ok {
+		tokens["left"] = bot.token
+	}
+	if bot, ok := right.(*botController); ok {
+		tokens["right"] = bot.token
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	return tokens
+}
+
+// observe starts a new decision window for bot and returns the observation
+// to send. Any command from the previous window is dropped. The bot's side is
+// looked up every time because players switch ends between games.
+func (g *GameState) observe(bot *botController, now time.Time) (botObservation, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.left != bot && g.right != bot {
+		return botObservation{}, false
+	}
+	side := g.sideOf(bot)
+	deadline := botDeadline()
+	bot.seq++
+	bot.deadline = now.Add(deadline)
+	g.setBotCommand(bot, side, "")
+
+	return botObservation{
+		Type:        "observation",
+		Seq:         bot.seq,
+		Side:        side,
+		DeadlineMs:  deadline.Milliseconds(),
+		TableWidth:  g.Physics.TableWidth,
+		TableHeight: g.Physics.TableHeight,
+		Ball:        g.Ball,
+		LeftPaddle:  g.LeftPaddle,
+		RightPaddle: g.RightPaddle,
+		LeftScore:   g.LeftScore,
+		RightScore:  g.RightScore,
+		LeftGames:   g.LeftGames,
+		RightGames:  g.RightGames,
+		Paused:      g.Paused,
+		GameOver:    g.GameOver,
+	}, true
+}
+
+func (g *GameState) submitBotCommand(bot *botController, cmd botCommand, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if (g.left != bot && g.right != bot) || cmd.Seq != bot.seq || now.After(bot.deadline) {
+		return
+	}
+	command := cmd.Command
+	if command != "up" && command != "down" {
+		command = ""
+	}
+	g.setBotCommand(bot, g.sideOf(bot), command)
+}
+
+func (g *GameState) setBotCommand(bot *botController, side, command string) {
+	if bot.command == command {
+		return
+	}
+	bot.command = command
+	g.recordInput(side, holdInput(command))
+}
+
+func (g *GameState) attachBot(side, token string) (*botController, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	bot, ok := g.controller(side).(*botController)
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(bot.token)) != 1 {
+		return nil, http.StatusForbidden
+	}
+	if bot.connected {
+		return nil, http.StatusConflict
+	}
+	bot.connected = true
+	return bot, http.StatusOK
+}
+
+func (g *GameState) detachBot(bot *botController) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	bot.connected = false
+	g.setBotCommand(bot, g.sideOf(bot), "")
+}
+
+func handleBot(w http.ResponseWriter, r *http.Request) {
+	room := roomFromRequest(w, r)
+	if room == nil {
+		return
+	}
+	side := r.URL.Query().Get("side")
+	if side != "left" && side != "right" {
+		http.Error(w, "side must be left or right", http.StatusBadRequest)
+		return
+	}
+	bot, status := room.Game.attachBot(side, r.URL.Query().Get("token"))
+	if bot == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer room.Game.detachBot(bot)
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := room.subscribe()
+	defer room.unsubscribe(sub)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			data, err := conn.readMessage()
+			if err != nil {
+				return
+			}
+			var cmd botCommand
+			if err := json.Unmarshal(data, &cmd); err != nil {
+				continue
+			}
+			room.Game.submitBotCommand(bot, cmd, time.Now())
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+			return
+		case <-sub.notify:
+			sub.drain()
+			observation, ok := room.Game.observe(bot, time.Now())
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(observation)
+			if err != nil {
+				continue
+			}
+			if err := conn.writeText(data); err != nil {
+				return
+			}
+		}
+	}
+}
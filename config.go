@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	defaultListen = ":8080"
+	envPrefix     = "PINGPONG_"
+)
+
+// Config is the server configuration. Values are taken from, in increasing
+// order of precedence: built-in defaults, the JSON config file, PINGPONG_*
+// environment variables and command-line flags.
+type Config struct {
+	Listen  string `json:"listen"`
+	TLSCert string `json:"tlsCert"`
+	TLSKey  string `json:"tlsKey"`
+	// ACMEDomains switches on automatic certificates for these names.
+	ACMEDomains   []string      `json:"acmeDomains"`
+	ACMEEmail     string        `json:"acmeEmail"`
+	ACMEDirectory string        `json:"acmeDirectory"`
+	ACMECA        string        `json:"acmeCA"`
+	ACMECache     string        `json:"acmeCache"`
+	RedirectHTTP  string        `json:"redirectHTTP"`
+	TickRate      int           `json:"tickRate"`
+	Rules         MatchRules    `json:"rules"`
+	Physics       PhysicsConfig `json:"physics"`
+	Recordings    string        `json:"recordings"`
+	History       string        `json:"history"`
+	Players       string        `json:"players"`
+	Ratings       string        `json:"ratings"`
+	Snapshot      string        `json:"snapshot"`
+	LogFormat     string        `json:"logFormat"`
+	LogLevel      string        `json:"logLevel"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		ACMEDirectory: acme.LetsEncryptURL,
+		ACMECache:     defaultACMECache,
+		TickRate:      defaultTickRate,
+		Rules:         defaultRules,
+		Physics:       standardPhysics,
+		Recordings:    defaultRecordingsDir,
+		History:       defaultHistoryPath,
+		Players:       defaultPlayersPath,
+		Ratings:       defaultRatingsPath,
+		Snapshot:      defaultSnapshotPath,
+		LogFormat:     "text",
+		LogLevel:      "info",
+	}
+}
+
+// configSetting ties a flag to its environment variable and Config field.
+type configSetting struct {
+	name  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, value string) error
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) configSetting {
+	return configSetting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+var configSettings = []configSetting{
+	stringSetting("listen", "address to listen on, e.g. :8080", func(c *Config) *string { return &c.Listen }),
+	stringSetting("tls-cert", "TLS certificate file; serves HTTPS when set with -tls-key", func(c *Config) *string { return &c.TLSCert }),
+	stringSetting("tls-key", "TLS private key file", func(c *Config) *string { return &c.TLSKey }),
+	{
+		name:  "acme-domains",
+		usage: "comma-separated domains to get certificates for via ACME",
+		get:   func(c *Config) string { return strings.Join(c.ACMEDomains, ",") },
+		set: func(c *Config, value string) error {
+			c.ACMEDomains = nil
+			for domain := range strings.SplitSeq(value, ",") {
+				if domain = strings.TrimSpace(domain); domain != "" {
+					c.ACMEDomains = append(c.ACMEDomains, domain)
+				}
+			}
+			return nil
+		},
+	},
+	stringSetting("acme-email", "contact email for the ACME account", func(c *Config) *string { return &c.ACMEEmail }),
+	stringSetting("acme-directory", "ACME directory URL", func(c *Config) *string { return &c.ACMEDirectory }),
+	stringSetting("acme-ca", "PEM file with the root that signed the ACME directory's certificate, for test CAs", func(c *Config) *string { return &c.ACMECA }),
+	stringSetting("acme-cache", "directory where ACME certificates and the account key are cached", func(c *Config) *string { return &c.ACMECache }),
+	stringSetting("redirect-http", "address of a plain HTTP listener that redirects to HTTPS, e.g. :80", func(c *Config) *string { return &c.RedirectHTTP }),
+	{
+		name:  "tick-rate",
+		usage: "game loop ticks per second",
+		get:   func(c *Config) string { return strconv.Itoa(c.TickRate) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			c.TickRate = n
+			return nil
+		},
+	},
+	stringSetting("recordings", "directory where match recordings are written", func(c *Config) *string { return &c.Recordings }),
+	stringSetting("history", "file where finished matches are stored", func(c *Config) *string { return &c.History }),
+	stringSetting("players", "file where player accounts are stored", func(c *Config) *string { return &c.Players }),
+	stringSetting("ratings", "file where player ratings are stored", func(c *Config) *string { return &c.Ratings }),
+	stringSetting("snapshot", "file where running games are saved on shutdown", func(c *Config) *string { return &c.Snapshot }),
+	stringSetting("log-format", "log output format: text or json", func(c *Config) *string { return &c.LogFormat }),
+	stringSetting("log-level", "lowest level logged: debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+}
+
+func (s configSetting) envName() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+// loadConfig builds the server configuration from args and the environment.
+func loadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet("ping-pong", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "JSON config file (env "+envPrefix+"CONFIG)")
+	defaults := defaultConfig()
+	flagValues := make(map[string]*string, len(configSettings))
+	for _, s := range configSettings {
+		flagValues[s.name] = fs.String(s.name, s.get(&defaults), s.usage+" (env "+s.envName()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	config := defaults
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return Config{}, fmt.Errorf("parsing %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range configSettings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			if err := s.set(&config, value); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.envName(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range configSettings {
+			if s.name == f.Name && flagErr == nil {
+				if err := s.set(&config, *flagValues[s.name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", s.name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	config.Rules = config.Rules.withDefaults()
+	return config, config.validate()
+}
+
+func (c Config) validate() error {
+	if c.TickRate < 1 || c.TickRate > maxTickRate {
+		return fmt.Errorf("tick rate must be between 1 and %d", maxTickRate)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS needs both a certificate and a key")
+	}
+	if c.TLSCert != "" && len(c.ACMEDomains) > 0 {
+		return errors.New("use either a TLS certificate or ACME, not both")
+	}
+	if c.RedirectHTTP != "" && !c.useTLS() {
+		return errors.New("redirecting to HTTPS needs TLS to be configured")
+	}
+	if err := c.Rules.validate(); err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+	if err := c.Physics.validate(); err != nil {
+		return fmt.Errorf("physics: %w", err)
+	}
+	if _, err := newLogger(io.Discard, c.LogFormat, c.LogLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c Config) useTLS() bool {
+	return c.TLSCert != "" || len(c.ACMEDomains) > 0
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// promptListen asks for the settings missing from the configuration the way
+// the server always did. It is only used when a terminal is attached and no
+// listen address was configured.
+func (c *Config) promptListen() error {
+	if !c.useTLS() {
+		useHTTPS := strings.ToLower(readInput("Use HTTPS? (yes/no): "))
+		if useHTTPS == "yes" || useHTTPS == "y" {
+			c.TLSCert = readInput("Certificate file path (cert.pem): ")
+			c.TLSKey = readInput("Private key file path (key.pem): ")
+			if c.TLSCert == "" || c.TLSKey == "" {
+				return errors.New("certificate and key files are required")
+			}
+		}
+	}
+
+	port := "80"
+	if c.useTLS() {
+		port = "443"
+	}
+	if portInput := readInput("Port (press Enter for " + port + "): "); portInput != "" {
+		port = portInput
+	}
+	c.Listen = ":" + port
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{"tickRate": 30, "history": "file.jsonl", "ratings": "file-ratings.json", "rules": {"pointsToWin": 5}}`)
+	t.Setenv(envPrefix+"CONFIG", path)
+	t.Setenv(envPrefix+"TICK_RATE", "45")
+	t.Setenv(envPrefix+"HISTORY", "env.jsonl")
+
+	config, err := loadConfig([]string{"-tick-rate", "90"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TickRate != 90 {
+		t.Errorf("tick rate %d, want the flag's 90", config.TickRate)
+	}
+	if config.History != "env.jsonl" {
+		t.Errorf("history %q, want the environment's env.jsonl", config.History)
+	}
+	if config.Ratings != "file-ratings.json" {
+		t.Errorf("ratings %q, want the file's file-ratings.json", config.Ratings)
+	}
+	if config.Snapshot != defaultSnapshotPath {
+		t.Errorf("snapshot %q, want the default %q", config.Snapshot, defaultSnapshotPath)
+	}
+	if config.Rules.PointsToWin != 5 || config.Rules.BestOf != defaultRules.BestOf {
+		t.Errorf("rules %+v, want 5 points from the file and the default best of", config.Rules)
+	}
+}
+
+func TestLoadConfigFlagNamesFile(t *testing.T) {
+	path := writeConfigFile(t, `{"players": "file-players.json"}`)
+	t.Setenv(envPrefix+"CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+
+	config, err := loadConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Players != "file-players.json" {
+		t.Errorf("players %q, want file-players.json from the -config file", config.Players)
+	}
+}
+
+func TestLoadConfigTickRate(t *testing.T) {
+	t.Setenv(envPrefix+"CONFIG", "")
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"1", true},
+		{"60", true},
+		{"1000", true},
+		{"0", false},
+		{"-5", false},
+		{"1001", false},
+		{"2000000000", false},
+		{"fast", false},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig([]string{"-tick-rate", tt.value})
+		if (err == nil) != tt.ok {
+			t.Errorf("-tick-rate %s: err %v, want ok %v", tt.value, err, tt.ok)
+		}
+	}
+}
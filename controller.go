@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	controllerHuman  = "human"
+	controllerAI     = "ai"
+	controllerBot    = "bot"
+	controllerOnline = "online"
+)
+
+type Controller interface {
+	Spec() string
+	Update(g *GameState, side string, dt float64)
+	Reset()
+}
+
+func parseController(spec string) (Controller, error) {
+	kind, difficulty, _ := strings.Cut(spec, ":")
+	switch kind {
+	case controllerHuman:
+		return &humanController{}, nil
+	case controllerAI:
+		if difficulty == "" {
+			difficulty = "medium"
+		}
+		if _, ok := aiProfiles[difficulty]; !ok {
+			return nil, fmt.Errorf("unknown difficulty %q", difficulty)
+		}
+		return &aiController{difficulty: difficulty}, nil
+	case controllerBot:
+		return &botController{token: randomHex(botTokenLength)}, nil
+	case controllerOnline:
+		return &onlineController{token: randomHex(seatTokenLength)}, nil
+	}
+	return nil, fmt.Errorf("unknown controller %q", spec)
+}
+
+func controllerKind(c Controller) string {
+	kind, _, _ := strings.Cut(c.Spec(), ":")
+	return kind
+}
+
+type humanController struct {
+	held heldInput
+}
+
+func (c *humanController) Spec() string {
+	return controllerHuman
+}
+
+func (c *humanController) Update(g *GameState, side string, dt float64) {
+	g.applyInput(g.paddle(side), c.held, dt)
+}
+
+func (c *humanController) Reset() {}
+
+func (g *GameState) paddle(side string) *Paddle {
+	if side == "left" {
+		return &g.LeftPaddle
+	}
+	return &g.RightPaddle
+}
+
+func (g *GameState) controller(side string) Controller {
+	if side == "left" {
+		return g.left
+	}
+	return g.right
+}
+
+// sideOf returns the paddle c currently controls. It changes when the
+// players switch ends between games.
+func (g *GameState) sideOf(c Controller) string {
+	if g.right == c {
+		return "right"
+	}
+	return "left"
+}
+
+func (g *GameState) setControllers(left, right Controller) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.useControllers(left, right)
+}
+
+// useControllers puts left and right in charge of the paddles. The caller
+// must hold g.mu.
+func (g *GameState) useControllers(left, right Controller) {
+	g.left, g.right = left, right
+	g.LeftController, g.RightController = left.Spec(), right.Spec()
+	g.GameMode = gameModeFor(left, right)
+	g.SidesSwitched = false
+	g.updateWaiting(time.Now())
+}
+
+// aiDifficulty returns the difficulty of the AI playing in a match, or
+// fallback when no AI plays.
+func aiDifficulty(left, right Controller, fallback string) string {
+	for _, c := range []Controller{right, left} {
+		if ai, ok := c.(*aiController); ok {
+			return ai.difficulty
+		}
+	}
+	return fallback
+}
+
+func gameModeFor(left, right Controller) string {
+	leftKind, rightKind := controllerKind(left), controllerKind(right)
+	switch {
+	case leftKind == controllerHuman && rightKind == controllerHuman:
+		return "2player"
+	case leftKind == controllerOnline && rightKind == controllerOnline:
+		return "online"
+	case leftKind == controllerHuman && rightKind == controllerAI,
+		leftKind == controllerAI && rightKind == controllerHuman:
+		return "ai"
+	}
+	return "custom"
+}
+
+func (g *GameState) setPlayers(left, right string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.LeftPlayer, g.RightPlayer = left, right
+}
+
+// sidePlayers puts the signed-in player on the first human-controlled paddle.
+func sidePlayers(name string, left, right Controller) (string, string) {
+	switch {
+	case name == "":
+		return "", ""
+	case controllerKind(left) == controllerHuman:
+		return name, ""
+	case controllerKind(right) == controllerHuman:
+		return "", name
+	}
+	return "", ""
+}
+
+func (g *GameState) playerName(side string) string {
+	if side == "left" {
+		return g.LeftPlayer
+	}
+	return g.RightPlayer
+}
+
+func (g *GameState) winnerMessage(side string) string {
+	if name := g.playerName(side); name != "" {
+		return name + " Wins!"
+	}
+	if g.GameMode == "ai" {
+		if controllerKind(g.controller(side)) == controllerHuman {
+			return "You Win!"
+		}
+		return "Computer Wins!"
+	}
+	if side == "left" {
+		return "Left Player Wins!"
+	}
+	return "Right Player Wins!"
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	eventPoint    = "point"
+	eventGame     = "game"
+	eventGameOver = "gameover"
+	eventPause    = "pause"
+	eventMenu     = "menu"
+
+	sseHeartbeatInterval = 15 * time.Second
+)
+
+type GameEvent struct {
+	Type       string `json:"type"`
+	MatchID    string `json:"matchId,omitempty"`
+	Side       string `json:"side,omitempty"`
+	Scorer     string `json:"scorer,omitempty"`
+	Winner     string `json:"winner,omitempty"`
+	Rally      int    `json:"rally,omitempty"`
+	Paused     bool   `json:"paused"`
+	LeftScore  int    `json:"leftScore"`
+	RightScore int    `json:"rightScore"`
+	LeftGames  int    `json:"leftGames"`
+	RightGames int    `json:"rightGames"`
+}
+
+func (g *GameState) emit(event GameEvent) {
+	event.MatchID = g.MatchID
+	event.LeftScore = g.LeftScore
+	event.RightScore = g.RightScore
+	event.LeftGames = g.LeftGames
+	event.RightGames = g.RightGames
+	g.events = append(g.events, event)
+	g.recordEvent(event)
+}
+
+func (g *GameState) takeEvents() []GameEvent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	events := g.events
+	g.events = nil
+	return events
+}
+
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	room, spectator := viewedRoom(w, r)
+	if room == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub, unsubscribe := room.follow(spectator)
+	defer unsubscribe(sub)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	var last []byte
+	for {
+		closing := false
+		select {
+		case <-r.Context().Done():
+			// The client went away or the server is shutting down; the
+			// latter still passes on the pause.
+			closing = true
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		case <-sub.notify:
+		}
+		snapshot, events := sub.drain()
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		if snapshot != nil && !bytes.Equal(snapshot, last) {
+			if _, err := fmt.Fprintf(w, "event: state\ndata: %s\n\n", snapshot); err != nil {
+				return
+			}
+			last = snapshot
+		}
+		flusher.Flush()
+		if closing {
+			return
+		}
+	}
+}
@@ -1,3 +1,10 @@
 module github.com/minasyans777/ping-pong
 
 go 1.25.5
+
+require golang.org/x/crypto v0.45.0
+
+require (
+	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/text v0.31.0 // indirect
+)
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHistoryPath = "history.jsonl"
+	defaultHistoryPage = 20
+	maxHistoryPage     = 100
+)
+
+var matchStore MatchStore
+
+type MatchRecord struct {
+	ID           string     `json:"id"`
+	LeftPlayer   string     `json:"leftPlayer"`
+	RightPlayer  string     `json:"rightPlayer"`
+	GameMode     string     `json:"gameMode"`
+	Difficulty   string     `json:"difficulty"`
+	LeftScore    int        `json:"leftScore"`
+	RightScore   int        `json:"rightScore"`
+	LeftGames    int        `json:"leftGames"`
+	RightGames   int        `json:"rightGames"`
+	Rules        MatchRules `json:"rules"`
+	Winner       string     `json:"winner"`
+	WinnerSide   string     `json:"winnerSide"`
+	StartedAt    time.Time  `json:"startedAt"`
+	EndedAt      time.Time  `json:"endedAt"`
+	DurationMs   int64      `json:"durationMs"`
+	Points       int        `json:"points"`
+	TotalHits    int        `json:"totalHits"`
+	LongestRally int        `json:"longestRally"`
+	AverageRally float64    `json:"averageRally"`
+}
+
+type MatchFilter struct {
+	Player string
+	Mode   string
+	Limit  int
+	Offset int
+}
+
+// matches reports whether m passes the filter. Player names match however
+// they are capitalised, like account names.
+func (f MatchFilter) matches(m MatchRecord) bool {
+	if player := accountKey(f.Player); player != "" &&
+		accountKey(m.LeftPlayer) != player && accountKey(m.RightPlayer) != player {
+		return false
+	}
+	if f.Mode != "" && m.GameMode != f.Mode {
+		return false
+	}
+	return true
+}
+
+type MatchStore interface {
+	Add(m MatchRecord) error
+	List(f MatchFilter) ([]MatchRecord, int, error)
+	Close() error
+}
+
+func matchRecordFrom(rec *Recording) MatchRecord {
+	m := MatchRecord{
+		ID:          rec.ID,
+		LeftPlayer:  recordedPlayer(rec.LeftPlayer, rec.LeftController),
+		RightPlayer: recordedPlayer(rec.RightPlayer, rec.RightController),
+		GameMode:    rec.GameMode,
+		Difficulty:  rec.Difficulty,
+		LeftScore:   rec.LeftScore,
+		RightScore:  rec.RightScore,
+		LeftGames:   rec.LeftGames,
+		RightGames:  rec.RightGames,
+		Rules:       rec.rules(),
+		Winner:      rec.Winner,
+		StartedAt:   rec.StartedAt,
+		EndedAt:     rec.EndedAt,
+		DurationMs:  (time.Duration(rec.Ticks) * physicsStep).Milliseconds(),
+	}
+	m.WinnerSide = "right"
+	if rec.leftWon() {
+		m.WinnerSide = "left"
+	}
+	for _, event := range rec.Events {
+		if event.Type != eventPoint {
+			continue
+		}
+		m.Points++
+		m.TotalHits += event.Rally
+		m.LongestRally = max(m.LongestRally, event.Rally)
+	}
+	if m.Points > 0 {
+		m.AverageRally = float64(m.TotalHits) / float64(m.Points)
+	}
+	return m
+}
+
+// recordedPlayer names an anonymous side after its controller, e.g. "ai:hard".
+func recordedPlayer(name, controller string) string {
+	if name != "" {
+		return name
+	}
+	return controller
+}
+
+// fileStore keeps match history as one JSON object per line. The whole file
+// is loaded at startup and new matches are appended.
+type fileStore struct {
+	mu      sync.RWMutex
+	file    *os.File
+	matches []MatchRecord
+}
+
+func openFileStore(path string) (*fileStore, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &fileStore{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var m MatchRecord
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			continue
+		}
+		store.matches = append(store.matches, m)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *fileStore) Add(m MatchRecord) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.matches = append(s.matches, m)
+	return nil
+}
+
+func (s *fileStore) List(f MatchFilter) ([]MatchRecord, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []MatchRecord{}
+	total := 0
+	for i := len(s.matches) - 1; i >= 0; i-- {
+		m := s.matches[i]
+		if !f.matches(m) {
+			continue
+		}
+		if total >= f.Offset && len(result) < f.Limit {
+			result = append(result, m)
+		}
+		total++
+	}
+	return result, total, nil
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultHistoryPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := MatchFilter{
+		Player: r.URL.Query().Get("player"),
+		Mode:   r.URL.Query().Get("mode"),
+		Limit:  min(max(limit, 1), maxHistoryPage),
+		Offset: offset,
+	}
+
+	matches, total, err := matchStore.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, struct {
+		Total   int           `json:"total"`
+		Limit   int           `json:"limit"`
+		Offset  int           `json:"offset"`
+		Matches []MatchRecord `json:"matches"`
+	}{total, filter.Limit, filter.Offset, matches})
+}
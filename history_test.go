@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func newTestFileStore(t *testing.T) *fileStore {
+	t.Helper()
+	store, err := openFileStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func matchIDs(matches []MatchRecord) []string {
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestFileStoreListFilters(t *testing.T) {
+	store := newTestFileStore(t)
+	for _, m := range []MatchRecord{
+		{ID: "m1", LeftPlayer: "Ann", RightPlayer: "ai:hard", GameMode: "ai"},
+		{ID: "m2", LeftPlayer: "bob", RightPlayer: "ANN", GameMode: "online"},
+		{ID: "m3", LeftPlayer: "bob", RightPlayer: "human", GameMode: "2player"},
+		{ID: "m4", LeftPlayer: "ai:easy", RightPlayer: "ann", GameMode: "ai"},
+	} {
+		if err := store.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter MatchFilter
+		want   []string
+	}{
+		{"all, newest first", MatchFilter{Limit: 10}, []string{"m4", "m3", "m2", "m1"}},
+		{"player in any case", MatchFilter{Player: "aNN", Limit: 10}, []string{"m4", "m2", "m1"}},
+		{"mode", MatchFilter{Mode: "ai", Limit: 10}, []string{"m4", "m1"}},
+		{"player and mode", MatchFilter{Player: "ann", Mode: "online", Limit: 10}, []string{"m2"}},
+		{"unknown player", MatchFilter{Player: "cat", Limit: 10}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, total, err := store.List(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(matchIDs(matches)); got != fmt.Sprint(tt.want) || total != len(tt.want) {
+				t.Errorf("matches %s of %d, want %v of %d", got, total, tt.want, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestFileStoreListPages(t *testing.T) {
+	store := newTestFileStore(t)
+	for i := 1; i <= 5; i++ {
+		player := "ann"
+		if i%2 == 0 {
+			player = "bob"
+		}
+		store.Add(MatchRecord{ID: fmt.Sprintf("m%d", i), LeftPlayer: player})
+	}
+
+	tests := []struct {
+		filter MatchFilter
+		want   []string
+		total  int
+	}{
+		{MatchFilter{Limit: 2}, []string{"m5", "m4"}, 5},
+		{MatchFilter{Limit: 2, Offset: 2}, []string{"m3", "m2"}, 5},
+		{MatchFilter{Limit: 2, Offset: 4}, []string{"m1"}, 5},
+		{MatchFilter{Limit: 2, Offset: 9}, []string{}, 5},
+		{MatchFilter{Player: "ann", Limit: 1, Offset: 1}, []string{"m3"}, 3},
+	}
+	for _, tt := range tests {
+		matches, total, err := store.List(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(matchIDs(matches)); got != fmt.Sprint(tt.want) || total != tt.total {
+			t.Errorf("%+v: matches %s of %d, want %v of %d", tt.filter, got, total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestFileStoreReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(MatchRecord{ID: "m1", LeftPlayer: "ann"})
+	store.Add(MatchRecord{ID: "m2", LeftPlayer: "bob"})
+	store.Close()
+
+	reopened, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	matches, total, _ := reopened.List(MatchFilter{Limit: 10})
+	if got := fmt.Sprint(matchIDs(matches)); got != "[m2 m1]" || total != 2 {
+		t.Errorf("matches %s of %d after reopening, want [m2 m1] of 2", got, total)
+	}
+}
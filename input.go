@@ -0,0 +1,93 @@
+package main
+
+import "math"
+
+// heldInput is what a player asks of a human paddle: a direction held down,
+// a height to steer to or an analog stick position. It stays in force until
+// the next input and every physics step applies it, so the paddle moves at
+// paddleSpeed however often, or however late, the client sends input.
+type heldInput struct {
+	action    string  // inputHold, inputTarget or inputAxis
+	direction string  // "up", "down" or "" when idle, for inputHold
+	value     float64 // the paddle centre for inputTarget, -1 to 1 for inputAxis
+}
+
+func holdInput(direction string) heldInput {
+	return heldInput{action: inputHold, direction: direction}
+}
+
+func (in heldInput) idle() bool {
+	return in.action == inputHold && in.direction == ""
+}
+
+// paddleInput is the input carried by /move requests and WebSocket
+// messages. A target wins over an axis, and either over a direction.
+type paddleInput struct {
+	Direction string   `json:"direction"`
+	Target    *float64 `json:"target"`
+	Axis      *float64 `json:"axis"`
+}
+
+func (p paddleInput) held() heldInput {
+	switch {
+	case p.Target != nil:
+		return heldInput{action: inputTarget, value: *p.Target}
+	case p.Axis != nil:
+		// A centred stick stops the paddle.
+		if *p.Axis == 0 {
+			return holdInput("")
+		}
+		return heldInput{action: inputAxis, value: math.Max(-1, math.Min(1, *p.Axis))}
+	case p.Direction == "up", p.Direction == "down":
+		return holdInput(p.Direction)
+	}
+	return holdInput("")
+}
+
+// applyInput moves p for one step of dt seconds, never faster than
+// paddleSpeed.
+func (g *GameState) applyInput(p *Paddle, in heldInput, dt float64) {
+	speed := g.Physics.PaddleSpeed
+	switch in.action {
+	case inputTarget:
+		offset := in.value - (p.Y + p.Height/2)
+		move := math.Min(math.Abs(offset), speed*dt)
+		if offset < 0 {
+			move = -move
+		}
+		p.Y = math.Max(0, math.Min(g.Physics.TableHeight-p.Height, p.Y+move))
+	case inputAxis:
+		p.Y = math.Max(0, math.Min(g.Physics.TableHeight-p.Height, p.Y+in.value*speed*dt))
+	default:
+		g.stepPaddle(p, in.direction, dt)
+	}
+}
+
+// heldBy returns the controller of the paddle on side, for releasing its
+// input later with releaseController.
+func (g *GameState) heldBy(side string) Controller {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.controller(side)
+}
+
+// releaseController stops the paddle c plays, wherever it is after
+// switching sides. Nothing happens once c has left the match.
+func (g *GameState) releaseController(c Controller) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.left == c || g.right == c {
+		g.releasePaddle(g.sideOf(c))
+	}
+}
+
+// releasePaddle stops a human paddle, for when its player can no longer
+// send input. The caller must hold g.mu.
+func (g *GameState) releasePaddle(side string) {
+	if human := g.human(side); human != nil && !human.held.idle() {
+		human.held = holdInput("")
+		g.recordInput(side, human.held)
+	}
+}
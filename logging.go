@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDLength = 8
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// newLogger builds the server logger. format is "text" or "json" and level
+// one of "debug", "info", "warn" or "error".
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// fatal logs err and exits, for errors the server cannot start without.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestLogger returns the default logger tagged with the request's ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With("request_id", requestID(r.Context()))
+}
+
+// logRequests gives every request an ID, taken from the X-Request-ID header
+// when the client sent a usable one, echoes it back and logs the request once
+// it is done. Failed requests are logged above debug level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = randomHex(requestIDLength)
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelDebug
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "request",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"handler", r.Pattern,
+			"status", rec.status,
+			"duration", time.Since(started),
+			"remote", r.RemoteAddr)
+	})
+}
+
+// writeJSON sends v as the response body. Encoding errors can only be
+// logged: by then the status line has been written.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		requestLogger(r).Warn("writing response", "path", r.URL.Path, "err", err)
+	}
+}
+
+func (r *Room) logger() *slog.Logger {
+	return slog.With("room", r.ID)
+}
+
+// logMatchStart records a match started or restarted by req.
+func (r *Room) logMatchStart(req *http.Request) {
+	g := r.Game
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	requestLogger(req).Info("match started",
+		"room", r.ID,
+		"match", g.MatchID,
+		"left", g.LeftController,
+		"right", g.RightController,
+		"seed", g.Seed)
+}
+
+// logEvents writes one log line per game event taken from the room.
+func (r *Room) logEvents(events []GameEvent) {
+	for _, event := range events {
+		logger := r.logger().With("match", event.MatchID)
+		switch event.Type {
+		case eventPoint:
+			logger.Info("point", "scorer", event.Scorer, "left_score", event.LeftScore, "right_score", event.RightScore, "rally", event.Rally)
+		case eventGame:
+			logger.Info("game won", "winner", event.Scorer, "left_games", event.LeftGames, "right_games", event.RightGames)
+		case eventGameOver:
+			logger.Info("match over", "winner", event.Winner, "left_games", event.LeftGames, "right_games", event.RightGames, "left_score", event.LeftScore, "right_score", event.RightScore)
+		case eventPause:
+			logger.Info("pause", "paused", event.Paused)
+		case eventMenu:
+			logger.Info("back to menu")
+		case eventConnect:
+			logger.Info("player connected", "side", event.Side)
+		case eventDisconnect:
+			logger.Info("player disconnected", "side", event.Side)
+		case eventAbandoned:
+			logger.Info("match abandoned", "side", event.Side)
+		}
+	}
+}
@@ -2,29 +2,38 @@ package main
 
 import (
 	"bufio"
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const (
-	tableWidth   = 1200
-	tableHeight  = 600
-	paddleWidth  = 20
-	paddleHeight = 120
-	ballRadius   = 10
-	paddleSpeed  = 10
-	maxScore     = 11
+	maxServeAngle   = math.Pi / 4
+	physicsStep     = time.Second / 60
+	maxFrameTime    = 250 * time.Millisecond
+	defaultTickRate = 60
+	maxTickRate     = 1000
+	// readHeaderTimeout bounds how long a client may take to send its
+	// request headers, so idle connections cannot pile up.
+	readHeaderTimeout = 10 * time.Second
 )
 
+var tickRate = defaultTickRate
+
 type Vec2 struct {
 	X float64 `json:"x"`
 	Y float64 `json:"y"`
@@ -42,260 +51,549 @@ type Paddle struct {
 	Width  float64 `json:"width"`
 }
 
+// InputAck tells a client which of its inputs for a paddle the server has
+// applied, so it can replay the rest on top of the server's paddle position.
+// Ticks counts the physics steps run since that input was applied.
+type InputAck struct {
+	Seq   uint64 `json:"seq"`
+	Ticks uint64 `json:"ticks"`
+}
+
 type GameState struct {
-	Ball        Ball   `json:"ball"`
-	LeftPaddle  Paddle `json:"leftPaddle"`
-	RightPaddle Paddle `json:"rightPaddle"`
-	LeftScore   int    `json:"leftScore"`
-	RightScore  int    `json:"rightScore"`
-	Paused      bool   `json:"paused"`
-	GameOver    bool   `json:"gameOver"`
-	Winner      string `json:"winner"`
-	GameMode    string `json:"gameMode"`
-	Difficulty  string `json:"difficulty"`
-	InMenu      bool   `json:"inMenu"`
-	mu          sync.Mutex
-}
-
-var game *GameState
+	Ball            Ball          `json:"ball"`
+	LeftPaddle      Paddle        `json:"leftPaddle"`
+	RightPaddle     Paddle        `json:"rightPaddle"`
+	LeftAck         InputAck      `json:"leftAck"`
+	RightAck        InputAck      `json:"rightAck"`
+	LeftScore       int           `json:"leftScore"`
+	RightScore      int           `json:"rightScore"`
+	LeftGames       int           `json:"leftGames"`
+	RightGames      int           `json:"rightGames"`
+	GameNumber      int           `json:"gameNumber"`
+	SidesSwitched   bool          `json:"sidesSwitched"`
+	Rules           MatchRules    `json:"rules"`
+	Physics         PhysicsConfig `json:"physics"`
+	Paused          bool          `json:"paused"`
+	GameOver        bool          `json:"gameOver"`
+	Winner          string        `json:"winner"`
+	GameMode        string        `json:"gameMode"`
+	Difficulty      string        `json:"difficulty"`
+	LeftController  string        `json:"leftController"`
+	RightController string        `json:"rightController"`
+	LeftPlayer      string        `json:"leftPlayer"`
+	RightPlayer     string        `json:"rightPlayer"`
+	InMenu          bool          `json:"inMenu"`
+	Rally           int           `json:"rally"`
+	Seed            int64         `json:"seed"`
+	Tick            uint64        `json:"tick"`
+	MatchID         string        `json:"matchId"`
+	JoinCode        string        `json:"-"`
+	WatchID         string        `json:"watchId"`
+	Viewers         int           `json:"viewers"`
+	Waiting         bool          `json:"waiting"`
+	RejoinBy        int64         `json:"rejoinBy,omitempty"`
+	mu              sync.Mutex
+	rng             *rand.Rand
+	source          *countingSource
+	left            Controller
+	right           Controller
+	events          []GameEvent
+	recordMatches   bool
+	recording       *Recording
+	archive         []*Recording
+}
+
+func newSeed() int64 {
+	return time.Now().UnixNano()
+}
 
 func newGame() *GameState {
-	rand.Seed(time.Now().UnixNano())
-	return &GameState{
+	return newSeededGame(newSeed())
+}
+
+func newSeededGame(seed int64) *GameState {
+	physics := defaultPhysics
+	g := &GameState{
 		Ball: Ball{
-			Pos:    Vec2{X: tableWidth / 2, Y: tableHeight / 2},
-			Vel:    Vec2{X: 6, Y: 4},
-			Radius: ballRadius,
+			Pos:    Vec2{X: physics.TableWidth / 2, Y: physics.TableHeight / 2},
+			Vel:    physics.ServeSpeed,
+			Radius: physics.BallRadius,
 		},
 		LeftPaddle: Paddle{
-			Y:      tableHeight/2 - paddleHeight/2,
-			Height: paddleHeight,
-			Width:  paddleWidth,
+			Y:      physics.TableHeight/2 - physics.PaddleHeight/2,
+			Height: physics.PaddleHeight,
+			Width:  physics.PaddleWidth,
 		},
 		RightPaddle: Paddle{
-			Y:      tableHeight/2 - paddleHeight/2,
-			Height: paddleHeight,
-			Width:  paddleWidth,
+			Y:      physics.TableHeight/2 - physics.PaddleHeight/2,
+			Height: physics.PaddleHeight,
+			Width:  physics.PaddleWidth,
 		},
-		LeftScore:  0,
-		RightScore: 0,
-		Paused:     false,
-		GameOver:   false,
-		GameMode:   "ai",
-		Difficulty: "medium",
-		InMenu:     true,
+		LeftScore:       0,
+		RightScore:      0,
+		Paused:          false,
+		GameOver:        false,
+		GameMode:        "ai",
+		Difficulty:      "medium",
+		LeftController:  controllerHuman,
+		RightController: controllerAI + ":medium",
+		InMenu:          true,
+		Rules:           defaultRules,
+		Physics:         physics,
+		GameNumber:      1,
+		Seed:            seed,
+		left:            &humanController{},
+		right:           &aiController{difficulty: "medium"},
 	}
+	g.seedRNG(0)
+	return g
 }
 
-func (g *GameState) reset() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+// resetPoint serves the next point. The caller must hold g.mu.
+func (g *GameState) resetPoint() {
+	g.serve()
+	g.centerPaddles()
+	g.left.Reset()
+	g.right.Reset()
+}
 
-	g.Ball.Pos = Vec2{X: tableWidth / 2, Y: tableHeight / 2}
+func (g *GameState) serve() {
+	speed := math.Hypot(g.Physics.ServeSpeed.X, g.Physics.ServeSpeed.Y)
+	angle := (g.rng.Float64()*2 - 1) * maxServeAngle
 	direction := 1.0
-	if g.RightScore > g.LeftScore {
+	if g.rng.Intn(2) == 0 {
 		direction = -1.0
 	}
-	g.Ball.Vel = Vec2{X: 6 * direction, Y: 4}
-	g.LeftPaddle.Y = tableHeight/2 - paddleHeight/2
-	g.RightPaddle.Y = tableHeight/2 - paddleHeight/2
+	g.Ball.Pos = Vec2{X: g.Physics.TableWidth / 2, Y: g.Physics.TableHeight / 2}
+	g.Ball.Vel = Vec2{X: direction * speed * math.Cos(angle), Y: speed * math.Sin(angle)}
+	g.Rally = 0
+}
+
+func (g *GameState) setSeed(seed int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.Seed = seed
 }
 
 func (g *GameState) resetGame() {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	g.restart()
+}
+
+// matchSetup is everything a match started from the menu is played with.
+type matchSetup struct {
+	left, right             Controller
+	leftPlayer, rightPlayer string
+	seed                    int64
+	rules                   MatchRules
+	physics                 PhysicsConfig
+	difficulty              string
+}
+
+// startMatch sets the game up for a new match and starts it in one go, so
+// the game loop never ticks a half-configured game. The player starting
+// an online match takes the first seat; its side and token are returned.
+func (g *GameState) startMatch(setup matchSetup, player string) (string, string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.useControllers(setup.left, setup.right)
+	g.LeftPlayer, g.RightPlayer = setup.leftPlayer, setup.rightPlayer
+	side, token, online := g.takeSeat(player)
+	g.Seed = setup.seed
+	g.Rules = setup.rules
+	g.usePhysics(setup.physics)
+	g.Difficulty = setup.difficulty
+	g.InMenu = false
+	g.restart()
+	return side, token, online
+}
+
+// restart puts the match back to its first serve. The caller must hold g.mu.
+func (g *GameState) restart() {
+	g.finishRecording()
+	if g.SidesSwitched {
+		g.switchSides()
+	}
 	g.LeftScore = 0
 	g.RightScore = 0
+	g.LeftGames = 0
+	g.RightGames = 0
+	g.GameNumber = 1
 	g.GameOver = false
 	g.Winner = ""
 	g.Paused = false
-	g.Ball.Pos = Vec2{X: tableWidth / 2, Y: tableHeight / 2}
-	g.Ball.Vel = Vec2{X: 6, Y: 4}
-	g.LeftPaddle.Y = tableHeight/2 - paddleHeight/2
-	g.RightPaddle.Y = tableHeight/2 - paddleHeight/2
+	g.seedRNG(0)
+	g.serve()
+	g.centerPaddles()
+	g.left.Reset()
+	g.right.Reset()
+	g.Tick = 0
+	g.updateWaiting(time.Now())
+	g.startRecording()
 }
 
-func (g *GameState) updateAI() {
-	if g.GameMode != "ai" || g.Paused || g.GameOver {
+func (g *GameState) update(dt float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.Paused || g.GameOver || g.InMenu || g.Waiting {
 		return
 	}
 
-	targetY := g.Ball.Pos.Y
-	paddleCenter := g.RightPaddle.Y + g.RightPaddle.Height/2
-
-	var aiSpeed float64
-	var reactionDelay float64
+	g.Tick++
+	g.left.Update(g, "left", dt)
+	g.right.Update(g, "right", dt)
+	g.LeftAck.Ticks++
+	g.RightAck.Ticks++
 
-	switch g.Difficulty {
-	case "easy":
-		aiSpeed = 4
-		reactionDelay = 50
-	case "medium":
-		aiSpeed = 7
-		reactionDelay = 20
-	case "hard":
-		aiSpeed = 10
-		reactionDelay = 5
-	}
+	g.moveBall(dt)
 
-	if math.Abs(targetY-paddleCenter) > reactionDelay {
-		if targetY > paddleCenter {
-			g.RightPaddle.Y = math.Min(tableHeight-g.RightPaddle.Height, g.RightPaddle.Y+aiSpeed)
-		} else {
-			g.RightPaddle.Y = math.Max(0, g.RightPaddle.Y-aiSpeed)
-		}
+	if g.Ball.Pos.X < 0 {
+		g.scorePoint("right")
+	} else if g.Ball.Pos.X > g.Physics.TableWidth {
+		g.scorePoint("left")
 	}
 }
 
-func (g *GameState) update() {
+func (g *GameState) togglePause() {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if g.Paused || g.GameOver || g.InMenu {
-		return
-	}
+	g.Paused = !g.Paused
+	g.emit(GameEvent{Type: eventPause, Paused: g.Paused})
+}
 
-	g.Ball.Pos.X += g.Ball.Vel.X
-	g.Ball.Pos.Y += g.Ball.Vel.Y
+func (g *GameState) backToMenu() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	if g.Ball.Pos.Y-g.Ball.Radius <= 0 || g.Ball.Pos.Y+g.Ball.Radius >= tableHeight {
-		g.Ball.Vel.Y = -g.Ball.Vel.Y
-		g.Ball.Pos.Y = math.Max(g.Ball.Radius, math.Min(tableHeight-g.Ball.Radius, g.Ball.Pos.Y))
-	}
+	g.InMenu = true
+	g.emit(GameEvent{Type: eventMenu})
+}
 
-	if g.Ball.Pos.X-g.Ball.Radius <= paddleWidth {
-		if g.Ball.Pos.Y >= g.LeftPaddle.Y && g.Ball.Pos.Y <= g.LeftPaddle.Y+g.LeftPaddle.Height {
-			relativeY := (g.Ball.Pos.Y - (g.LeftPaddle.Y + g.LeftPaddle.Height/2)) / (g.LeftPaddle.Height / 2)
-			angle := relativeY * math.Pi / 3
-			speed := math.Sqrt(g.Ball.Vel.X*g.Ball.Vel.X + g.Ball.Vel.Y*g.Ball.Vel.Y)
-			speed *= 1.08
-			g.Ball.Vel.X = speed * math.Cos(angle)
-			g.Ball.Vel.Y = speed * math.Sin(angle)
-			g.Ball.Pos.X = paddleWidth + g.Ball.Radius
-		}
+func (g *GameState) stepPaddle(p *Paddle, direction string, dt float64) {
+	speed := g.Physics.PaddleSpeed
+	switch direction {
+	case "up":
+		p.Y = math.Max(0, p.Y-speed*dt)
+	case "down":
+		p.Y = math.Min(g.Physics.TableHeight-p.Height, p.Y+speed*dt)
 	}
+}
 
-	if g.Ball.Pos.X+g.Ball.Radius >= tableWidth-paddleWidth {
-		if g.Ball.Pos.Y >= g.RightPaddle.Y && g.Ball.Pos.Y <= g.RightPaddle.Y+g.RightPaddle.Height {
-			relativeY := (g.Ball.Pos.Y - (g.RightPaddle.Y + g.RightPaddle.Height/2)) / (g.RightPaddle.Height / 2)
-			angle := relativeY * math.Pi / 3
-			speed := math.Sqrt(g.Ball.Vel.X*g.Ball.Vel.X + g.Ball.Vel.Y*g.Ball.Vel.Y)
-			speed *= 1.08
-			g.Ball.Vel.X = -speed * math.Cos(angle)
-			g.Ball.Vel.Y = speed * math.Sin(angle)
-			g.Ball.Pos.X = tableWidth - paddleWidth - g.Ball.Radius
-		}
-	}
+// movePaddle replays an inputMove from an older recording: one physics
+// step in direction.
+func (g *GameState) movePaddle(paddle string, direction string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	if g.Ball.Pos.X < 0 {
-		g.RightScore++
-		if g.RightScore >= maxScore {
-			g.GameOver = true
-			if g.GameMode == "ai" {
-				g.Winner = "Computer Wins!"
-			} else {
-				g.Winner = "Right Player Wins!"
-			}
-		} else {
-			g.mu.Unlock()
-			g.reset()
-			g.mu.Lock()
-		}
-	} else if g.Ball.Pos.X > tableWidth {
-		g.LeftScore++
-		if g.LeftScore >= maxScore {
-			g.GameOver = true
-			if g.GameMode == "ai" {
-				g.Winner = "You Win!"
-			} else {
-				g.Winner = "Left Player Wins!"
-			}
-		} else {
-			g.mu.Unlock()
-			g.reset()
-			g.mu.Lock()
-		}
+	if paddle != "left" && paddle != "right" {
+		return
+	}
+	if g.human(paddle) != nil {
+		g.stepPaddle(g.paddle(paddle), direction, physicsStep.Seconds())
 	}
 }
 
-func (g *GameState) movePaddle(paddle string, direction string) {
+// holdPaddle sets the input a human paddle applies on every step until the
+// next one. A non-zero seq is the client's number for the input and is
+// acknowledged in the paddle's InputAck.
+func (g *GameState) holdPaddle(paddle string, in heldInput, seq uint64) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if paddle == "left" {
-		if direction == "up" {
-			g.LeftPaddle.Y = math.Max(0, g.LeftPaddle.Y-paddleSpeed)
-		} else {
-			g.LeftPaddle.Y = math.Min(tableHeight-g.LeftPaddle.Height, g.LeftPaddle.Y+paddleSpeed)
-		}
-	} else if paddle == "right" {
-		if direction == "up" {
-			g.RightPaddle.Y = math.Max(0, g.RightPaddle.Y-paddleSpeed)
-		} else {
-			g.RightPaddle.Y = math.Min(tableHeight-g.RightPaddle.Height, g.RightPaddle.Y+paddleSpeed)
-		}
+	if paddle != "left" && paddle != "right" {
+		return
+	}
+	human := g.human(paddle)
+	if human == nil {
+		return
+	}
+	if human.held != in {
+		human.held = in
+		g.recordInput(paddle, in)
+	}
+	if seq != 0 {
+		*g.inputAck(paddle) = InputAck{Seq: seq}
+	}
+}
+
+func (g *GameState) inputAck(side string) *InputAck {
+	if side == "left" {
+		return &g.LeftAck
 	}
+	return &g.RightAck
 }
 
 func handleState(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	game.mu.Lock()
-	defer game.mu.Unlock()
-	json.NewEncoder(w).Encode(game)
+	room, _ := viewedRoom(w, r)
+	if room == nil {
+		return
+	}
+	room.Game.mu.Lock()
+	defer room.Game.mu.Unlock()
+	writeJSON(w, r, room.Game)
 }
 
 func handleMove(w http.ResponseWriter, r *http.Request) {
+	room := roomFromRequest(w, r)
+	if room == nil {
+		return
+	}
 	var req struct {
-		Paddle    string `json:"paddle"`
-		Direction string `json:"direction"`
+		Paddle string `json:"paddle"`
+		paddleInput
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	game.movePaddle(req.Paddle, req.Direction)
+	paddle, status := room.Game.moveSide(req.Paddle, r.URL.Query().Get("token"))
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	room.Game.holdPaddle(paddle, req.held(), 0)
 	w.WriteHeader(http.StatusOK)
 }
 
 func handlePause(w http.ResponseWriter, r *http.Request) {
-	game.mu.Lock()
-	game.Paused = !game.Paused
-	game.mu.Unlock()
+	room := controlledRoom(w, r)
+	if room == nil {
+		return
+	}
+	room.Game.togglePause()
 	w.WriteHeader(http.StatusOK)
 }
 
 func handleReset(w http.ResponseWriter, r *http.Request) {
-	game.resetGame()
+	room := controlledRoom(w, r)
+	if room == nil {
+		return
+	}
+	var req struct {
+		Seed *int64 `json:"seed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seed := newSeed()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+	room.Game.setSeed(seed)
+	room.Game.resetGame()
+	room.logMatchStart(r)
 	w.WriteHeader(http.StatusOK)
 }
 
 func handleStartGame(w http.ResponseWriter, r *http.Request) {
+	if spectating(r) {
+		forbidSpectator(w)
+		return
+	}
 	var req struct {
-		GameMode   string `json:"gameMode"`
-		Difficulty string `json:"difficulty"`
+		GameMode   string        `json:"gameMode"`
+		Difficulty string        `json:"difficulty"`
+		Left       string        `json:"left"`
+		Right      string        `json:"right"`
+		Seed       *int64        `json:"seed"`
+		Rules      MatchRules    `json:"rules"`
+		Physics    PhysicsConfig `json:"physics"`
 	}
+	// Physics fields left out of the request keep the server defaults.
+	req.Physics = defaultPhysics
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	game.mu.Lock()
-	game.GameMode = req.GameMode
-	game.Difficulty = req.Difficulty
-	game.InMenu = false
-	game.mu.Unlock()
-	game.resetGame()
-	w.WriteHeader(http.StatusOK)
+	rules := req.Rules.withDefaults()
+	if err := rules.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Physics.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Difficulty == "" {
+		req.Difficulty = "medium"
+	}
+	if req.Left == "" {
+		req.Left = controllerHuman
+		if req.GameMode == "online" {
+			req.Left = controllerOnline
+		}
+	}
+	if req.Right == "" {
+		req.Right = controllerHuman
+		switch req.GameMode {
+		case "ai":
+			req.Right = controllerAI + ":" + req.Difficulty
+		case "online":
+			req.Right = controllerOnline
+		}
+	}
+	left, err := parseController(req.Left)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	right, err := parseController(req.Right)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var room *Room
+	if id := r.URL.Query().Get(roomQueryParameter); id != "" {
+		room = rooms.get(id)
+	}
+	// A started online match keeps its seats; a new match gets a new room.
+	if room != nil && room.Game.hasSeats() {
+		http.Error(w, "room has an online match", http.StatusConflict)
+		return
+	}
+	if room == nil {
+		room = rooms.create()
+	}
+	if room == nil {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	setup := matchSetup{
+		left:       left,
+		right:      right,
+		seed:       newSeed(),
+		rules:      rules,
+		physics:    req.Physics,
+		difficulty: aiDifficulty(left, right, req.Difficulty),
+	}
+	if req.Seed != nil {
+		setup.seed = *req.Seed
+	}
+	setup.leftPlayer, setup.rightPlayer = sidePlayers(currentPlayer(r), left, right)
+	side, token, online := room.Game.startMatch(setup, currentPlayer(r))
+	// The player who took the first seat shares the join code for the other.
+	var joinCode string
+	if online {
+		joinCode = rooms.joinCode(room)
+	}
+	room.logMatchStart(r)
+
+	writeJSON(w, r, struct {
+		Room      string            `json:"room"`
+		BotTokens map[string]string `json:"botTokens,omitempty"`
+		JoinCode  string            `json:"joinCode,omitempty"`
+		Side      string            `json:"side,omitempty"`
+		Token     string            `json:"token,omitempty"`
+	}{room.ID, botTokens(left, right), joinCode, side, token})
 }
 
 func handleBackToMenu(w http.ResponseWriter, r *http.Request) {
-	game.mu.Lock()
-	game.InMenu = true
-	game.mu.Unlock()
-	game.resetGame()
+	room := controlledRoom(w, r)
+	if room == nil {
+		return
+	}
+	room.Game.backToMenu()
+	room.Game.resetGame()
 	w.WriteHeader(http.StatusOK)
 }
 
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	room, spectator := viewedRoom(w, r)
+	if room == nil {
+		return
+	}
+	// A player in an online match connects with their seat token; the
+	// connection keeps the seat occupied.
+	token := r.URL.Query().Get("token")
+	if token != "" && !spectator {
+		seat, status := room.Game.attachSeat(token, time.Now())
+		if seat == nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		defer room.Game.detachSeat(seat, time.Now())
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub, unsubscribe := room.follow(spectator)
+	defer unsubscribe(sub)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Paddles moved from this connection stop when it closes. Seats
+		// are released by detachSeat once their last connection is gone.
+		held := make(map[Controller]bool)
+		defer func() {
+			for c := range held {
+				room.Game.releaseController(c)
+			}
+		}()
+
+		for {
+			data, err := conn.readMessage()
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Type   string `json:"type"`
+				Paddle string `json:"paddle"`
+				Seq    uint64 `json:"seq"`
+				paddleInput
+			}
+			if spectator || json.Unmarshal(data, &msg) != nil {
+				continue
+			}
+			room.touch()
+			paddle, status := room.Game.moveSide(msg.Paddle, token)
+			if status != http.StatusOK {
+				continue
+			}
+			switch msg.Type {
+			case "move", "hold":
+				room.Game.holdPaddle(paddle, msg.held(), msg.Seq)
+				if token == "" {
+					held[room.Game.heldBy(paddle)] = true
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+			// The server is shutting down: pass on the pause and close.
+			if snapshot, _ := sub.drain(); snapshot != nil {
+				conn.writeText(snapshot)
+			}
+			return
+		case <-sub.notify:
+			snapshot, _ := sub.drain()
+			if snapshot == nil {
+				continue
+			}
+			if err := conn.writeText(snapshot); err != nil {
+				return
+			}
+		}
+	}
+}
+
 func handleIndex(w http.ResponseWriter, r *http.Request) {
 	html := `<!DOCTYPE html>
 <html>
@@ -369,6 +667,69 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
             font-size: 24px;
             background: linear-gradient(135deg, #11998e 0%, #38ef7d 100%);
         }
+        #playerBox {
+            margin-bottom: 10px;
+            font-size: 16px;
+        }
+        #playerBox input {
+            padding: 10px;
+            margin: 5px;
+            border-radius: 8px;
+            border: none;
+            font-size: 15px;
+            width: 220px;
+        }
+        #playerBox button {
+            padding: 8px 18px;
+            font-size: 14px;
+            margin: 5px;
+        }
+        #playerMessage {
+            margin-top: 8px;
+            color: #FFD700;
+            word-break: break-all;
+        }
+        #rulesSection label {
+            margin: 0 10px;
+            font-size: 16px;
+        }
+        #rulesSection select {
+            padding: 6px;
+            margin-left: 5px;
+            border-radius: 6px;
+            border: none;
+            font-size: 15px;
+        }
+        #joinSection input {
+            padding: 10px;
+            margin: 5px;
+            border-radius: 8px;
+            border: none;
+            font-size: 18px;
+            width: 140px;
+            text-transform: uppercase;
+            text-align: center;
+        }
+        #joinMessage {
+            margin-top: 8px;
+            color: #FFD700;
+        }
+        #leaderboard {
+            margin-top: 30px;
+        }
+        #leaderboard table {
+            margin: 0 auto;
+            border-collapse: collapse;
+            font-size: 16px;
+        }
+        #leaderboard td {
+            padding: 4px 14px;
+            text-align: left;
+        }
+        #leaderboard tr.anchor {
+            color: #aaa;
+            font-style: italic;
+        }
         #gameArea {
             display: none;
         }
@@ -406,6 +767,19 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
             text-shadow: 2px 2px 4px rgba(0,0,0,0.5);
             min-width: 120px;
         }
+        #setScore {
+            font-size: 18px;
+            color: #FFD700;
+        }
+        #viewers {
+            font-size: 18px;
+        }
+        #watchLink a {
+            color: #FFD700;
+        }
+        body.spectator .player-only {
+            display: none;
+        }
         #gameContainer {
             position: relative;
             box-shadow: 0 15px 50px rgba(0,0,0,0.6);
@@ -416,6 +790,9 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
         canvas {
             display: block;
             background: #0a4d2e;
+            max-width: 95vw;
+            max-height: 75vh;
+            object-fit: contain;
         }
         #gameOver {
             position: absolute;
@@ -429,6 +806,30 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
             display: none;
             border: 3px solid #FFD700;
         }
+        #waiting {
+            position: absolute;
+            top: 50%;
+            left: 50%;
+            transform: translate(-50%, -50%);
+            background: rgba(0,0,0,0.9);
+            padding: 40px;
+            border-radius: 20px;
+            text-align: center;
+            display: none;
+            border: 3px solid #ADD8E6;
+        }
+        #waiting h2 {
+            font-size: 32px;
+            margin-bottom: 20px;
+            color: #ADD8E6;
+        }
+        #waiting p { margin: 10px 0; font-size: 18px; }
+        #joinCodeText {
+            font-size: 40px;
+            font-weight: bold;
+            letter-spacing: 6px;
+            color: #FFD700;
+        }
         #gameOver h1 {
             font-size: 52px;
             margin-bottom: 30px;
@@ -458,6 +859,21 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 <body>
     <div id="mainMenu">
         <h1>🏓 PING PONG</h1>
+        <div id="playerBox">
+            <div id="signedOut">
+                <input id="playerName" placeholder="Display name" maxlength="24">
+                <input id="playerSecret" type="password" placeholder="Password or token (optional)">
+                <div>
+                    <button onclick="register()">📝 Register</button>
+                    <button onclick="login()">🔑 Sign in</button>
+                </div>
+            </div>
+            <div id="signedIn" style="display: none;">
+                Signed in as <strong id="playerLabel"></strong>
+                <button onclick="logout()">🚪 Sign out</button>
+            </div>
+            <p id="playerMessage"></p>
+        </div>
         <div class="menu-section">
             <h2>Game Mode</h2>
             <div class="button-group">
@@ -467,6 +883,9 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
                 <button class="menu-btn" onclick="selectMode('2player')">
                     👥 2 Players
                 </button>
+                <button class="menu-btn" onclick="selectMode('online')">
+                    🌐 Online
+                </button>
             </div>
         </div>
         <div class="menu-section" id="difficultySection">
@@ -483,30 +902,74 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
                 </button>
             </div>
         </div>
+        <div class="menu-section" id="rulesSection">
+            <h2>Rules</h2>
+            <label>Points
+                <select id="pointsToWin">
+                    <option value="5">5</option>
+                    <option value="11" selected>11</option>
+                    <option value="21">21</option>
+                </select>
+            </label>
+            <label>Best of
+                <select id="bestOf">
+                    <option value="1" selected>1</option>
+                    <option value="3">3</option>
+                    <option value="5">5</option>
+                    <option value="7">7</option>
+                </select>
+            </label>
+            <p>
+                <label><input type="checkbox" id="winByTwo"> Win by two</label>
+                <label><input type="checkbox" id="switchSides"> Switch sides</label>
+            </p>
+        </div>
         <button id="startBtn" class="menu-btn" onclick="startGame()">
             ▶️ Start Game
         </button>
+        <div class="menu-section" id="joinSection">
+            <h2>Join an Online Match</h2>
+            <input id="joinCode" placeholder="Code" maxlength="6">
+            <button onclick="joinGame(document.getElementById('joinCode').value)">🌐 Join</button>
+            <p id="joinMessage"></p>
+        </div>
+        <div id="leaderboard">
+            <h2>🏆 Leaderboard</h2>
+            <table id="leaderboardTable"></table>
+        </div>
     </div>
     <div id="gameArea">
         <div id="controlPanel">
             <div id="score">0 : 0</div>
-            <button id="pauseBtn" onclick="togglePause()">⏸️ Pause</button>
-            <button id="menuBtn" onclick="backToMenu()">🏠 Menu</button>
+            <div id="setScore"></div>
+            <div id="viewers"></div>
+            <button id="pauseBtn" class="player-only" onclick="togglePause()">⏸️ Pause</button>
+            <button id="menuBtn" class="player-only" onclick="backToMenu()">🏠 Menu</button>
         </div>
         <div id="gameContainer">
-            <canvas id="canvas" width="1200" height="600"></canvas>
+            <canvas id="canvas"></canvas>
+            <div id="waiting">
+                <h2 id="waitingTitle"></h2>
+                <div id="lobbyInfo">
+                    <p>Share this code with your opponent:</p>
+                    <p id="joinCodeText"></p>
+                    <p>or send them this link:</p>
+                    <p><a id="joinLink" style="color: #FFD700;"></a></p>
+                </div>
+            </div>
             <div id="gameOver">
                 <h1 id="winnerText"></h1>
-                <button onclick="playAgain()" style="font-size: 22px; padding: 15px 40px;">
+                <button class="player-only" onclick="playAgain()" style="font-size: 22px; padding: 15px 40px;">
                     🔄 Play Again
                 </button>
-                <button onclick="backToMenu()" style="font-size: 22px; padding: 15px 40px; margin-left: 15px;">
+                <button class="player-only" onclick="backToMenu()" style="font-size: 22px; padding: 15px 40px; margin-left: 15px;">
                     🏠 Menu
                 </button>
             </div>
         </div>
         <div id="controls">
             <p id="controlsText"></p>
+            <p id="watchLink" class="player-only"></p>
         </div>
     </div>
     <script>
@@ -515,8 +978,41 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
         const keys = {};
         let selectedMode = 'ai';
         let selectedDifficulty = 'medium';
-        window.addEventListener('keydown', e => keys[e.key.toLowerCase()] = true);
-        window.addEventListener('keyup', e => keys[e.key.toLowerCase()] = false);
+        let roomId = null;
+        let socket = null;
+        let reconnectAttempts = 0;
+        const maxReconnectAttempts = 15;
+        const held = {left: 'none', right: 'none'};
+        let controllers = {left: 'human', right: 'ai'};
+        // seat is this browser's side and token in an online match.
+        let seat = null;
+        let mySide = null;
+        // watchId is set when this page only watches a room.
+        let watchId = null;
+        // The page draws its own paddles where its inputs will have taken
+        // them, replaying the inputs the server has not acknowledged yet on
+        // top of the last state. The ball and other paddles are drawn a few
+        // ticks in the past, interpolated between states.
+        const stepMs = 1000 / 60;
+        const interpolationTicks = 3;
+        const maxSnapshots = 30;
+        let snapshots = [];
+        let latest = null;
+        let latestAt = 0;
+        let inputSeq = 0;
+        let rtt = 0;
+        let resendHolds = false;
+        const pending = {left: [], right: []};
+        const acked = {left: {direction: 'none', at: 0}, right: {direction: 'none', at: 0}};
+        const anchors = {left: null, right: null};
+        window.addEventListener('keydown', e => {
+            keys[e.key.toLowerCase()] = true;
+            sendHolds();
+        });
+        window.addEventListener('keyup', e => {
+            keys[e.key.toLowerCase()] = false;
+            sendHolds();
+        });
         function selectMode(mode) {
             selectedMode = mode;
             document.querySelectorAll('.menu-section')[0].querySelectorAll('.menu-btn').forEach(btn => {
@@ -533,44 +1029,300 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
             });
             event.target.classList.add('selected');
         }
-        async function startGame() {
-            await fetch('/start', {
+        function showPlayer(name) {
+            document.getElementById('signedOut').style.display = name ? 'none' : 'block';
+            document.getElementById('signedIn').style.display = name ? 'block' : 'none';
+            document.getElementById('playerLabel').textContent = name || '';
+        }
+        function showPlayerMessage(text) {
+            document.getElementById('playerMessage').textContent = text;
+        }
+        async function loadPlayer() {
+            const res = await fetch('/me');
+            showPlayer(res.ok ? (await res.json()).name : null);
+        }
+        async function sendCredentials(path) {
+            const res = await fetch(path, {
                 method: 'POST',
                 headers: {'Content-Type': 'application/json'},
                 body: JSON.stringify({
-                    gameMode: selectedMode,
-                    difficulty: selectedDifficulty
+                    name: document.getElementById('playerName').value,
+                    password: document.getElementById('playerSecret').value
                 })
             });
+            if (!res.ok) {
+                showPlayerMessage(await res.text());
+                return null;
+            }
+            document.getElementById('playerSecret').value = '';
+            const data = await res.json();
+            showPlayer(data.name);
+            return data;
+        }
+        async function register() {
+            const data = await sendCredentials('/register');
+            if (!data) return;
+            showPlayerMessage(data.token
+                ? 'Your login token is ' + data.token + ' - keep it to sign in again.'
+                : '');
+        }
+        async function login() {
+            if (await sendCredentials('/login')) showPlayerMessage('');
+        }
+        async function logout() {
+            await fetch('/logout', {method: 'POST'});
+            showPlayer(null);
+            showPlayerMessage('');
+        }
+        async function loadLeaderboard() {
+            const res = await fetch('/leaderboard?limit=10');
+            if (!res.ok) return;
+            const table = document.getElementById('leaderboardTable');
+            table.innerHTML = '';
+            (await res.json()).forEach((entry, i) => {
+                const row = table.insertRow();
+                if (entry.anchor) row.className = 'anchor';
+                row.insertCell().textContent = (i + 1) + '.';
+                row.insertCell().textContent = entry.name;
+                row.insertCell().textContent = Math.round(entry.rating);
+                row.insertCell().textContent = entry.anchor ? '' : entry.wins + 'W ' + entry.losses + 'L';
+            });
+        }
+        function roomURL(path) {
+            if (watchId) return path + '?watch=' + encodeURIComponent(watchId);
+            let url = path + '?room=' + encodeURIComponent(roomId);
+            if (seat) url += '&token=' + encodeURIComponent(seat.token);
+            return url;
+        }
+        function takeSeat(room, side, token, joinCode) {
+            roomId = room;
+            seat = {side, token, joinCode};
+            mySide = side;
+            sessionStorage.setItem('pp_seat', JSON.stringify(seat));
+            sessionStorage.setItem('pp_room', room);
+        }
+        function leaveSeat() {
+            seat = null;
+            mySide = null;
+            sessionStorage.removeItem('pp_seat');
+            sessionStorage.removeItem('pp_room');
+        }
+        function startWatching(id) {
+            watchId = id;
+            document.body.classList.add('spectator');
+            document.getElementById('controlsText').innerHTML = '<p><strong>Spectating</strong></p>';
+            connect();
+            document.getElementById('mainMenu').style.display = 'none';
+            document.getElementById('gameArea').style.display = 'block';
+        }
+        function showGame() {
+            connect();
             document.getElementById('mainMenu').style.display = 'none';
             document.getElementById('gameArea').style.display = 'block';
             updateControlsText();
         }
+        async function joinGame(code) {
+            const res = await fetch('/join', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({code: code})
+            });
+            if (!res.ok) {
+                document.getElementById('joinMessage').textContent = await res.text();
+                return;
+            }
+            document.getElementById('joinMessage').textContent = '';
+            const data = await res.json();
+            selectedMode = 'online';
+            takeSeat(data.room, data.side, data.token);
+            showGame();
+        }
+        // rejoin takes this tab back to its online match after a reload.
+        async function rejoin() {
+            const saved = JSON.parse(sessionStorage.getItem('pp_seat') || 'null');
+            const room = sessionStorage.getItem('pp_room');
+            if (!saved || !room) return false;
+            const res = await fetch('/state?room=' + encodeURIComponent(room));
+            if (!res.ok) {
+                leaveSeat();
+                return false;
+            }
+            selectedMode = 'online';
+            takeSeat(room, saved.side, saved.token, saved.joinCode);
+            showGame();
+            return true;
+        }
+        async function startGame() {
+            if (selectedMode === 'online') {
+                roomId = null;
+            }
+            leaveSeat();
+            const url = roomId ? roomURL('/start') : '/start';
+            const res = await fetch(url, {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({
+                    gameMode: selectedMode,
+                    difficulty: selectedDifficulty,
+                    rules: selectedRules()
+                })
+            });
+            const data = await res.json();
+            roomId = data.room;
+            if (data.token) takeSeat(data.room, data.side, data.token, data.joinCode);
+            showGame();
+        }
+        function selectedRules() {
+            return {
+                pointsToWin: parseInt(document.getElementById('pointsToWin').value),
+                bestOf: parseInt(document.getElementById('bestOf').value),
+                winByTwo: document.getElementById('winByTwo').checked,
+                switchSides: document.getElementById('switchSides').checked
+            };
+        }
+        function rulesText(rules) {
+            let text = 'The first player to reach ' + rules.pointsToWin + ' points' +
+                (rules.winByTwo ? ', two clear,' : '') + ' wins the game';
+            if (rules.bestOf > 1) {
+                text += '. Best of ' + rules.bestOf + ' games' +
+                    (rules.switchSides ? ', switching sides after each game' : '');
+            }
+            return text + '!';
+        }
         function updateControlsText() {
-            const text = selectedMode === 'ai'
-                ? '<p><strong>Controls:</strong> <span class="control-key">W</span> (Up) / <span class="control-key">S</span> (Down)</p><p>The first player to reach 11 points wins!</p>'
-                : '<p><strong>Left Player:</strong> <span class="control-key">W</span> (Up) / <span class="control-key">S</span> (Down)</p><p><strong>Right Player:</strong> <span class="control-key">↑</span> (Up) / <span class="control-key">↓</span> (Down)</p><p>The first player to reach 11 points wins!</p>';
+            const rules = '<p>' + rulesText(selectedRules()) + '</p>';
+            let text;
+            if (selectedMode === 'ai') {
+                text = '<p><strong>Controls:</strong> <span class="control-key">W</span> (Up) / <span class="control-key">S</span> (Down)</p>' + rules;
+            } else if (seat) {
+                text = '<p><strong>You play the ' + (mySide === 'left' ? 'blue left' : 'red right') + ' paddle:</strong> <span class="control-key">W</span> / <span class="control-key">↑</span> (Up), <span class="control-key">S</span> / <span class="control-key">↓</span> (Down)</p>';
+            } else {
+                text = '<p><strong>Left Player:</strong> <span class="control-key">W</span> (Up) / <span class="control-key">S</span> (Down)</p><p><strong>Right Player:</strong> <span class="control-key">↑</span> (Up) / <span class="control-key">↓</span> (Down)</p>' + rules;
+            }
             document.getElementById('controlsText').innerHTML = text;
         }
         async function backToMenu() {
-            await fetch('/menu', {method: 'POST'});
+            disconnect();
+            await fetch(roomURL('/menu'), {method: 'POST'});
+            showMenu();
+        }
+        function showMenu() {
+            if (seat) {
+                leaveSeat();
+                roomId = null;
+            }
+            loadLeaderboard();
             document.getElementById('mainMenu').style.display = 'block';
             document.getElementById('gameArea').style.display = 'none';
             document.getElementById('gameOver').style.display = 'none';
+            document.getElementById('waiting').style.display = 'none';
         }
         async function playAgain() {
-            await fetch('/reset', {method: 'POST'});
+            await fetch(roomURL('/reset'), {method: 'POST'});
             document.getElementById('gameOver').style.display = 'none';
         }
-        async function movePaddle(paddle, direction) {
-            await fetch('/move', {
-                method: 'POST',
-                headers: {'Content-Type': 'application/json'},
-                body: JSON.stringify({paddle, direction})
-            });
+        function connect() {
+            disconnect();
+            latest = null;
+            snapshots = [];
+            const scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+            const ws = new WebSocket(scheme + location.host + roomURL('/ws'));
+            ws.onopen = () => {
+                reconnectAttempts = 0;
+                held.left = 'none';
+                held.right = 'none';
+                // The server released our paddles when the last connection went.
+                for (const paddle of ['left', 'right']) {
+                    pending[paddle] = [];
+                    acked[paddle] = {direction: 'none', at: 0};
+                    anchors[paddle] = null;
+                }
+                resendHolds = true;
+            };
+            ws.onmessage = e => {
+                const state = JSON.parse(e.data);
+                controllers = {left: state.leftController, right: state.rightController};
+                if (seat) {
+                    const switched = state.sidesSwitched ? (seat.side === 'left' ? 'right' : 'left') : seat.side;
+                    if (switched !== mySide) {
+                        mySide = switched;
+                        updateControlsText();
+                    }
+                }
+                if (state.inMenu) {
+                    // The other player went back to the menu.
+                    if (seat) {
+                        disconnect();
+                        showMenu();
+                    }
+                    if (watchId) showBetweenMatches();
+                    return;
+                }
+                receiveState(state, performance.now());
+                updateHUD(state);
+                // Once we know our paddles, send their holds again so the
+                // server's acks line its clock up with ours.
+                if (resendHolds) {
+                    resendHolds = false;
+                    for (const paddle of ownPaddles()) held[paddle] = null;
+                    sendHolds();
+                }
+            };
+            ws.onclose = () => {
+                if (socket !== ws) return;
+                socket = null;
+                // The server may be restarting; its rooms come back paused.
+                // Spectator screens keep trying for as long as they are open.
+                if (watchId || reconnectAttempts < maxReconnectAttempts) {
+                    reconnectAttempts++;
+                    setTimeout(() => { if ((roomId || watchId) && !socket) connect(); }, 2000);
+                    return;
+                }
+                reconnectAttempts = 0;
+                roomId = null;
+                leaveSeat();
+                showMenu();
+            };
+            socket = ws;
+        }
+        function disconnect() {
+            if (!socket) return;
+            const ws = socket;
+            socket = null;
+            ws.close();
+        }
+        function holdDirection(upKey, downKey) {
+            if (keys[upKey] && !keys[downKey]) return 'up';
+            if (keys[downKey] && !keys[upKey]) return 'down';
+            return 'none';
+        }
+        function sendHolds() {
+            if (watchId || !socket || socket.readyState !== WebSocket.OPEN) return;
+            const leftHuman = controllers.left === 'human';
+            const rightHuman = controllers.right === 'human';
+            const next = {left: 'none', right: 'none'};
+            if (seat) {
+                const up = keys['w'] || keys['arrowup'];
+                const down = keys['s'] || keys['arrowdown'];
+                next[mySide] = up && !down ? 'up' : down && !up ? 'down' : 'none';
+            } else if (leftHuman && rightHuman) {
+                next.left = holdDirection('w', 's');
+                next.right = holdDirection('arrowup', 'arrowdown');
+            } else if (leftHuman || rightHuman) {
+                const up = keys['w'] || keys['arrowup'];
+                const down = keys['s'] || keys['arrowdown'];
+                next[leftHuman ? 'left' : 'right'] = up && !down ? 'up' : down && !up ? 'down' : 'none';
+            }
+            for (const paddle of ['left', 'right']) {
+                if (next[paddle] === held[paddle]) continue;
+                held[paddle] = next[paddle];
+                const seq = ++inputSeq;
+                pending[paddle].push({seq, direction: next[paddle], at: performance.now()});
+                socket.send(JSON.stringify({type: 'hold', paddle, direction: next[paddle], seq}));
+            }
         }
         async function togglePause() {
-            await fetch('/pause', {method: 'POST'});
+            await fetch(roomURL('/pause'), {method: 'POST'});
         }
         function drawTable() {
             ctx.fillStyle = '#0a4d2e';
@@ -587,47 +1339,198 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
             ctx.stroke();
             ctx.setLineDash([]);
         }
-        function draw(state) {
+        function running(state) {
+            return !state.paused && !state.gameOver && !state.waiting && !state.inMenu;
+        }
+        function ownPaddles() {
+            if (watchId) return [];
+            if (seat) return [mySide];
+            return ['left', 'right'].filter(paddle => controllers[paddle] === 'human');
+        }
+        function receiveState(state, now) {
+            if (latest && state.tick < latest.tick) snapshots = [];
+            // Our inputs follow the paddles when the players change ends.
+            if (latest && state.sidesSwitched !== latest.sidesSwitched) {
+                for (const sides of [pending, acked, anchors]) [sides.left, sides.right] = [sides.right, sides.left];
+                snapshots = [];
+            }
+            snapshots.push(state);
+            if (snapshots.length > maxSnapshots) snapshots.shift();
+            latest = state;
+            latestAt = now;
+            for (const paddle of ['left', 'right']) {
+                const ack = state[paddle + 'Ack'];
+                // After a reload our numbering restarts below the server's.
+                if (pending[paddle].length === 0 && ack.seq > inputSeq) inputSeq = ack.seq;
+                while (pending[paddle].length > 0 && pending[paddle][0].seq <= ack.seq) {
+                    acked[paddle] = pending[paddle].shift();
+                    anchors[paddle] = {at: acked[paddle].at, ticks: 0};
+                    rtt = Math.max(0, now - acked[paddle].at - ack.ticks * stepMs);
+                }
+                // Ticks stop while the game is held, so line the server's
+                // clock up with ours again once it runs.
+                if (!running(state)) {
+                    anchors[paddle] = null;
+                } else if (!anchors[paddle]) {
+                    anchors[paddle] = {at: now - rtt, ticks: ack.ticks};
+                }
+            }
+        }
+        // stepPaddle moves a paddle like the server's stepPaddle.
+        function stepPaddle(y, direction, ms, paddle) {
+            const distance = latest.physics.paddleSpeed * ms / 1000;
+            if (direction === 'up') return Math.max(0, y - distance);
+            if (direction === 'down') return Math.min(latest.physics.tableHeight - paddle.height, y + distance);
+            return y;
+        }
+        function predictPaddle(paddle, now) {
+            const server = latest[paddle + 'Paddle'];
+            const anchor = anchors[paddle];
+            if (!running(latest) || !anchor) return server.y;
+            // The moment on our clock that the server's paddle position
+            // corresponds to, counted from the last input it applied.
+            let t = Math.min(now, anchor.at + (latest[paddle + 'Ack'].ticks - anchor.ticks) * stepMs);
+            let y = server.y;
+            let direction = acked[paddle].direction;
+            for (const input of pending[paddle]) {
+                if (input.at > t) {
+                    y = stepPaddle(y, direction, input.at - t, server);
+                    t = input.at;
+                }
+                direction = input.direction;
+            }
+            return stepPaddle(y, direction, now - t, server);
+        }
+        function lerp(a, b, f) {
+            return a + (b - a) * f;
+        }
+        function interpolated(now) {
+            let renderTick = latest.tick - interpolationTicks;
+            if (running(latest)) {
+                renderTick += Math.min(interpolationTicks, (now - latestAt) / stepMs);
+            }
+            let a = snapshots[0];
+            let b = latest;
+            for (let i = snapshots.length - 1; i > 0; i--) {
+                if (snapshots[i - 1].tick <= renderTick) {
+                    a = snapshots[i - 1];
+                    b = snapshots[i];
+                    break;
+                }
+            }
+            if (renderTick <= a.tick || b.tick <= a.tick) return {ball: a.ball, left: a.leftPaddle.y, right: a.rightPaddle.y};
+            if (renderTick >= b.tick) return {ball: b.ball, left: b.leftPaddle.y, right: b.rightPaddle.y};
+            const f = (renderTick - a.tick) / (b.tick - a.tick);
+            // A new serve puts the ball back in the middle; don't slide it there.
+            const served = a.leftScore !== b.leftScore || a.rightScore !== b.rightScore || a.gameNumber !== b.gameNumber;
+            const ball = served ? b.ball : {
+                pos: {x: lerp(a.ball.pos.x, b.ball.pos.x, f), y: lerp(a.ball.pos.y, b.ball.pos.y, f)},
+                radius: b.ball.radius
+            };
+            return {
+                ball,
+                left: lerp(a.leftPaddle.y, b.leftPaddle.y, f),
+                right: lerp(a.rightPaddle.y, b.rightPaddle.y, f)
+            };
+        }
+        function drawFrame(now) {
+            const state = latest;
+            if (canvas.width !== state.physics.tableWidth || canvas.height !== state.physics.tableHeight) {
+                canvas.width = state.physics.tableWidth;
+                canvas.height = state.physics.tableHeight;
+            }
+            const view = interpolated(now);
+            for (const paddle of ownPaddles()) {
+                view[paddle] = predictPaddle(paddle, now);
+            }
             drawTable();
             ctx.fillStyle = '#2196F3';
             ctx.shadowBlur = 20;
             ctx.shadowColor = '#2196F3';
-            ctx.fillRect(0, state.leftPaddle.y, state.leftPaddle.width, state.leftPaddle.height);
+            ctx.fillRect(0, view.left, state.leftPaddle.width, state.leftPaddle.height);
             ctx.fillStyle = '#F44336';
             ctx.shadowColor = '#F44336';
-            ctx.fillRect(canvas.width - state.rightPaddle.width, state.rightPaddle.y,
+            ctx.fillRect(canvas.width - state.rightPaddle.width, view.right,
                         state.rightPaddle.width, state.rightPaddle.height);
             ctx.shadowBlur = 25;
             ctx.shadowColor = '#FFFF00';
             ctx.fillStyle = 'white';
             ctx.beginPath();
-            ctx.arc(state.ball.pos.x, state.ball.pos.y, state.ball.radius, 0, Math.PI * 2);
+            ctx.arc(view.ball.pos.x, view.ball.pos.y, view.ball.radius, 0, Math.PI * 2);
             ctx.fill();
             ctx.shadowBlur = 0;
+        }
+        function frame() {
+            if (latest && !latest.inMenu && document.getElementById('gameArea').style.display === 'block') {
+                drawFrame(performance.now());
+            }
+            requestAnimationFrame(frame);
+        }
+        function updateHUD(state) {
             document.getElementById('score').textContent =
-                state.leftScore + ' : ' + state.rightScore;
+                (state.leftPlayer ? state.leftPlayer + '  ' : '') +
+                state.leftScore + ' : ' + state.rightScore +
+                (state.rightPlayer ? '  ' + state.rightPlayer : '');
+            document.getElementById('setScore').textContent = state.rules.bestOf > 1
+                ? 'Games ' + state.leftGames + ' : ' + state.rightGames +
+                  ' · Game ' + state.gameNumber + ' of ' + state.rules.bestOf
+                : '';
             document.getElementById('pauseBtn').innerHTML =
                 state.paused ? '▶️ Resume' : '⏸️ Pause';
             if (state.gameOver) {
                 document.getElementById('winnerText').textContent = state.winner;
                 document.getElementById('gameOver').style.display = 'block';
             }
+            document.getElementById('viewers').textContent = state.viewers > 0 ? '👀 ' + state.viewers : '';
+            if (!watchId && state.watchId) {
+                const link = location.origin + '/?watch=' + state.watchId;
+                document.getElementById('watchLink').innerHTML =
+                    '📺 Spectators can watch at <a href="' + link + '" target="_blank">' + link + '</a>';
+            }
+            drawWaiting(state);
         }
-        async function gameLoop() {
-            const res = await fetch('/state');
-            const state = await res.json();
-            if (!state.inMenu) {
-                if (keys['w']) await movePaddle('left', 'up');
-                if (keys['s']) await movePaddle('left', 'down');
-                if (state.gameMode === '2player') {
-                    if (keys['arrowup']) await movePaddle('right', 'up');
-                    if (keys['arrowdown']) await movePaddle('right', 'down');
-                }
-                draw(state);
+        function showBetweenMatches() {
+            document.getElementById('gameOver').style.display = 'none';
+            document.getElementById('waitingTitle').textContent = 'Waiting for the next match...';
+            document.getElementById('lobbyInfo').style.display = 'none';
+            document.getElementById('waiting').style.display = 'block';
+        }
+        function drawWaiting(state) {
+            const box = document.getElementById('waiting');
+            if (!state.waiting || state.gameOver) {
+                box.style.display = 'none';
+                return;
+            }
+            const lobby = state.tick === 0 && !state.rejoinBy;
+            const joinCode = seat && seat.joinCode;
+            let title = lobby ? 'Waiting for an opponent...' : 'Waiting for the other player...';
+            if (state.rejoinBy) {
+                const seconds = Math.max(0, Math.ceil((state.rejoinBy - Date.now()) / 1000));
+                title = 'Opponent disconnected - ' + seconds + 's to rejoin';
+            }
+            document.getElementById('waitingTitle').textContent = title;
+            document.getElementById('lobbyInfo').style.display = lobby && joinCode ? 'block' : 'none';
+            if (joinCode) {
+                const link = location.origin + '/?join=' + joinCode;
+                document.getElementById('joinCodeText').textContent = joinCode;
+                document.getElementById('joinLink').textContent = link;
+                document.getElementById('joinLink').href = link;
             }
-            requestAnimationFrame(gameLoop);
+            box.style.display = 'block';
+        }
+        requestAnimationFrame(frame);
+        loadPlayer();
+        loadLeaderboard();
+        const params = new URLSearchParams(location.search);
+        const joinParam = params.get('join');
+        if (params.get('watch')) {
+            startWatching(params.get('watch'));
+        } else if (joinParam) {
+            history.replaceState(null, '', '/');
+            joinGame(joinParam);
+        } else {
+            rejoin();
         }
-        gameLoop();
     </script>
 </body>
 </html>`
@@ -635,13 +1538,54 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, html)
 }
 
-func gameLoop() {
-	ticker := time.NewTicker(16 * time.Millisecond)
+// gameLoop advances every room until ctx is cancelled.
+func gameLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second / time.Duration(tickRate))
 	defer ticker.Stop()
 
-	for range ticker.C {
-		game.updateAI()
-		game.update()
+	reaper := time.NewTicker(roomReapInterval)
+	defer reaper.Stop()
+
+	dt := physicsStep.Seconds()
+	last := time.Now()
+	var accumulator time.Duration
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(last)
+			if elapsed > maxFrameTime {
+				metrics.observeDroppedSteps(int((elapsed - maxFrameTime) / physicsStep))
+			}
+			accumulator += min(elapsed, maxFrameTime)
+			last = now
+
+			started := time.Now()
+			active := rooms.active()
+			steps := 0
+			for ; accumulator >= physicsStep; accumulator -= physicsStep {
+				for _, room := range active {
+					room.Game.update(dt)
+				}
+				steps++
+			}
+			for _, room := range active {
+				room.Game.expireSeats(now)
+				// Clients and bots only hear about ticks that moved the
+				// game; faster tick rates than the physics add no frames.
+				if steps > 0 {
+					room.broadcast()
+				}
+				for _, rec := range room.Game.takeArchive() {
+					archiveInBackground(rec)
+				}
+			}
+			metrics.observeTick(time.Since(started), steps)
+		case now := <-reaper.C:
+			rooms.reap(now)
+		}
 	}
 }
 
@@ -653,8 +1597,71 @@ func readInput(prompt string) string {
 }
 
 func main() {
-	game = newGame()
-	go gameLoop()
+	if len(os.Args) > 1 {
+		var command func([]string) error
+		switch os.Args[1] {
+		case "simulate":
+			command = runSimulate
+		case "replay":
+			command = runReplay
+		}
+		if command != nil {
+			if err := command(os.Args[2:]); err != nil {
+				fatal(os.Args[1]+" failed", err)
+			}
+			return
+		}
+	}
+
+	config, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("loading configuration", err)
+	}
+	logger, err := newLogger(os.Stderr, config.LogFormat, config.LogLevel)
+	if err != nil {
+		fatal("setting up logging", err)
+	}
+	slog.SetDefault(logger)
+	tickRate = config.TickRate
+	recordingsDir = config.Recordings
+	defaultRules = config.Rules
+	defaultPhysics = config.Physics
+
+	store, err := openFileStore(config.History)
+	if err != nil {
+		fatal("opening match history", err)
+	}
+	defer store.Close()
+	matchStore = store
+
+	players, err = openPlayerStore(config.Players)
+	if err != nil {
+		fatal("opening player accounts", err)
+	}
+	ratings, err = openRatingStore(config.Ratings)
+	if err != nil {
+		fatal("opening player ratings", err)
+	}
+
+	rooms = newRoomRegistry()
+	restored, err := restoreSnapshot(config.Snapshot, rooms)
+	if err != nil {
+		slog.Error("restoring snapshot", "path", config.Snapshot, "err", err)
+	}
+	if restored > 0 {
+		slog.Info("restored snapshot", "rooms", restored, "path", config.Snapshot)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	loopDone := make(chan struct{})
+	go func() {
+		gameLoop(ctx)
+		close(loopDone)
+	}()
 
 	http.HandleFunc("/", handleIndex)
 	http.HandleFunc("/state", handleState)
@@ -663,49 +1670,65 @@ func main() {
 	http.HandleFunc("/reset", handleReset)
 	http.HandleFunc("/start", handleStartGame)
 	http.HandleFunc("/menu", handleBackToMenu)
+	http.HandleFunc("/join", handleJoin)
+	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/bot", handleBot)
+	http.HandleFunc("/replay/{id}", handleReplay)
+	http.HandleFunc("/history", handleHistory)
+	http.HandleFunc("/register", handleRegister)
+	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/logout", handleLogout)
+	http.HandleFunc("/me", handleMe)
+	http.HandleFunc("/leaderboard", handleLeaderboard)
+	http.HandleFunc("/players/{name}/rating", handlePlayerRating)
+	http.HandleFunc("/metrics", handleMetrics)
 
-	fmt.Println("🏓 Ping Pong Game Server")
-	fmt.Println("========================")
-
-	useHTTPS := readInput("Use HTTPS? (yes/no): ")
-
-	if strings.ToLower(useHTTPS) == "yes" || strings.ToLower(useHTTPS) == "y" {
-		certFile := readInput("Certificate file path (cert.pem): ")
-		keyFile := readInput("Private key file path (key.pem): ")
-
-		if certFile == "" || keyFile == "" {
-			log.Fatal("Error: Certificate and key files are required")
-		}
-
-		portInput := readInput("Port (press Enter for 443): ")
-		port := ":443"
-		if portInput != "" {
-			port = ":" + portInput
+	if config.Listen == "" {
+		if !stdinIsTerminal() {
+			config.Listen = defaultListen
+		} else {
+			fmt.Println("🏓 Ping Pong Game Server")
+			fmt.Println("========================")
+			if err := config.promptListen(); err != nil {
+				fatal("reading settings", err)
+			}
 		}
+	}
 
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
+	server := &http.Server{
+		Addr:              config.Listen,
+		Handler:           logRequests(instrument(http.DefaultServeMux)),
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext:       func(net.Listener) context.Context { return streams },
+	}
+	servers := []*http.Server{server}
+	serverErr := make(chan error, 2)
+	if config.useTLS() {
+		setup, err := newTLSSetup(config)
+		if err != nil {
+			fatal("setting up TLS", err)
 		}
+		server.TLSConfig = setup.config
 
-		server := &http.Server{
-			Addr:      port,
-			TLSConfig: tlsConfig,
+		if config.RedirectHTTP != "" {
+			redirect := setup.redirectServer(config.RedirectHTTP, config.Listen)
+			servers = append(servers, redirect)
+			slog.Info("redirecting HTTP to HTTPS", "addr", config.RedirectHTTP)
+			go func() { serverErr <- redirect.ListenAndServe() }()
 		}
 
-		fmt.Printf("✅ Server running with HTTPS on https://localhost%s\n", port)
-		fmt.Println("Open your browser and start playing!")
-
-		log.Fatal(server.ListenAndServeTLS(certFile, keyFile))
+		slog.Info("server listening", "addr", config.Listen, "tls", true)
+		go func() { serverErr <- server.ListenAndServeTLS("", "") }()
 	} else {
-		portInput := readInput("Port (press Enter for 80): ")
-		port := ":80"
-		if portInput != "" {
-			port = ":" + portInput
-		}
-
-		fmt.Printf("✅ Server running with HTTP on http://localhost%s\n", port)
-		fmt.Println("Open your browser and start playing!")
+		slog.Info("server listening", "addr", config.Listen, "tls", false)
+		go func() { serverErr <- server.ListenAndServe() }()
+	}
 
-		log.Fatal(http.ListenAndServe(port, nil))
+	select {
+	case err := <-serverErr:
+		fatal("serving HTTP", err)
+	case <-ctx.Done():
 	}
+	shutdown(loopDone, config.Snapshot, servers...)
 }
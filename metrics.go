@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tickBuckets are the upper bounds, in seconds, of the tick duration
+// histogram. A tick at 60Hz has about 16ms to spare.
+var tickBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.016, 0.025, 0.05, 0.1}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+type matchKey struct {
+	mode       string
+	difficulty string
+}
+
+type aiResultKey struct {
+	difficulty string
+	result     string
+}
+
+// serverMetrics collects the counters exposed on /metrics in the Prometheus
+// text format. Gauges such as active rooms are read at scrape time instead.
+type serverMetrics struct {
+	mu           sync.Mutex
+	tickCounts   []uint64
+	tickCount    uint64
+	tickSum      float64
+	ticksBehind  uint64
+	requests     map[requestKey]uint64
+	points       uint64
+	matches      map[matchKey]uint64
+	aiResults    map[aiResultKey]uint64
+	droppedSteps uint64
+}
+
+var metrics = newServerMetrics()
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		tickCounts: make([]uint64, len(tickBuckets)),
+		requests:   make(map[requestKey]uint64),
+		matches:    make(map[matchKey]uint64),
+		aiResults:  make(map[aiResultKey]uint64),
+	}
+}
+
+// observeTick records how long one pass of the game loop took and how many
+// physics steps beyond the first it had to run to catch up.
+func (m *serverMetrics) observeTick(d time.Duration, steps int) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, bound := range tickBuckets {
+		if seconds <= bound {
+			m.tickCounts[i]++
+		}
+	}
+	m.tickCount++
+	m.tickSum += seconds
+	if steps > 1 {
+		m.ticksBehind += uint64(steps - 1)
+	}
+}
+
+// observeDroppedSteps records physics steps skipped because a frame took
+// longer than maxFrameTime.
+func (m *serverMetrics) observeDroppedSteps(steps int) {
+	m.mu.Lock()
+	m.droppedSteps += uint64(steps)
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) observeRequest(handler string, code int) {
+	m.mu.Lock()
+	m.requests[requestKey{handler, code}]++
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) observeEvents(events []GameEvent) {
+	points := 0
+	for _, event := range events {
+		if event.Type == eventPoint {
+			points++
+		}
+	}
+	if points == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.points += uint64(points)
+	m.mu.Unlock()
+}
+
+// observeMatch counts a completed match. When exactly one side was the
+// built-in AI, the AI's result is counted by difficulty.
+func (m *serverMetrics) observeMatch(rec *Recording) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.matches[matchKey{rec.GameMode, rec.Difficulty}]++
+
+	leftKind, leftDifficulty, _ := strings.Cut(rec.LeftController, ":")
+	rightKind, rightDifficulty, _ := strings.Cut(rec.RightController, ":")
+	switch {
+	case leftKind == controllerAI && rightKind != controllerAI:
+		m.aiResults[aiResultKey{leftDifficulty, aiResult(rec.leftWon())}]++
+	case rightKind == controllerAI && leftKind != controllerAI:
+		m.aiResults[aiResultKey{rightDifficulty, aiResult(!rec.leftWon())}]++
+	}
+}
+
+func aiResult(won bool) string {
+	if won {
+		return "win"
+	}
+	return "loss"
+}
+
+func (m *serverMetrics) write(w io.Writer) {
+	list := rooms.active()
+	clients, viewers := 0, 0
+	for _, room := range list {
+		clients += room.clientCount()
+		viewers += room.Game.viewerCount()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "pingpong_rooms_active", "gauge", "Rooms currently held by the server.")
+	fmt.Fprintf(w, "pingpong_rooms_active %d\n", len(list))
+	writeHeader(w, "pingpong_clients_connected", "gauge", "WebSocket and SSE clients subscribed to a room.")
+	fmt.Fprintf(w, "pingpong_clients_connected %d\n", clients)
+	writeHeader(w, "pingpong_spectators_connected", "gauge", "Clients watching a room through its watch ID.")
+	fmt.Fprintf(w, "pingpong_spectators_connected %d\n", viewers)
+
+	writeHeader(w, "pingpong_tick_duration_seconds", "histogram", "Time spent advancing and broadcasting all rooms per game loop tick.")
+	for i, bound := range tickBuckets {
+		fmt.Fprintf(w, "pingpong_tick_duration_seconds_bucket{le=%q} %d\n", formatFloat(bound), m.tickCounts[i])
+	}
+	fmt.Fprintf(w, "pingpong_tick_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.tickCount)
+	fmt.Fprintf(w, "pingpong_tick_duration_seconds_sum %s\n", formatFloat(m.tickSum))
+	fmt.Fprintf(w, "pingpong_tick_duration_seconds_count %d\n", m.tickCount)
+	writeHeader(w, "pingpong_ticks_behind_schedule_total", "counter", "Extra physics steps run to catch up with the wall clock.")
+	fmt.Fprintf(w, "pingpong_ticks_behind_schedule_total %d\n", m.ticksBehind)
+	writeHeader(w, "pingpong_ticks_dropped_total", "counter", "Physics steps skipped because a frame took longer than the maximum frame time.")
+	fmt.Fprintf(w, "pingpong_ticks_dropped_total %d\n", m.droppedSteps)
+
+	writeHeader(w, "pingpong_http_requests_total", "counter", "HTTP requests by handler and status code.")
+	for _, key := range slices.SortedFunc(maps.Keys(m.requests), func(a, b requestKey) int {
+		if c := strings.Compare(a.handler, b.handler); c != 0 {
+			return c
+		}
+		return a.code - b.code
+	}) {
+		fmt.Fprintf(w, "pingpong_http_requests_total{handler=%q,code=\"%d\"} %d\n", key.handler, key.code, m.requests[key])
+	}
+
+	writeHeader(w, "pingpong_points_total", "counter", "Points scored in all rooms.")
+	fmt.Fprintf(w, "pingpong_points_total %d\n", m.points)
+
+	writeHeader(w, "pingpong_matches_completed_total", "counter", "Matches played to the end by game mode and difficulty.")
+	for _, key := range slices.SortedFunc(maps.Keys(m.matches), func(a, b matchKey) int {
+		if c := strings.Compare(a.mode, b.mode); c != 0 {
+			return c
+		}
+		return strings.Compare(a.difficulty, b.difficulty)
+	}) {
+		fmt.Fprintf(w, "pingpong_matches_completed_total{mode=%q,difficulty=%q} %d\n", key.mode, key.difficulty, m.matches[key])
+	}
+
+	writeHeader(w, "pingpong_ai_matches_total", "counter", "Completed matches against the built-in AI by difficulty and AI result.")
+	wins := make(map[string]uint64)
+	totals := make(map[string]uint64)
+	for _, key := range slices.SortedFunc(maps.Keys(m.aiResults), func(a, b aiResultKey) int {
+		if c := strings.Compare(a.difficulty, b.difficulty); c != 0 {
+			return c
+		}
+		return strings.Compare(a.result, b.result)
+	}) {
+		count := m.aiResults[key]
+		fmt.Fprintf(w, "pingpong_ai_matches_total{difficulty=%q,result=%q} %d\n", key.difficulty, key.result, count)
+		totals[key.difficulty] += count
+		if key.result == "win" {
+			wins[key.difficulty] += count
+		}
+	}
+	writeHeader(w, "pingpong_ai_win_ratio", "gauge", "Share of completed matches against the built-in AI that the AI won.")
+	for _, difficulty := range slices.Sorted(maps.Keys(totals)) {
+		ratio := float64(wins[difficulty]) / float64(totals[difficulty])
+		fmt.Fprintf(w, "pingpong_ai_win_ratio{difficulty=%q} %s\n", difficulty, formatFloat(ratio))
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
+
+// statusRecorder remembers the status code written by a handler. It passes
+// Flush and Hijack through so SSE and WebSocket handlers keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	s.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// instrument counts requests by the mux pattern that served them.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		handler := r.Pattern
+		if handler == "" {
+			handler = "unmatched"
+		}
+		metrics.observeRequest(handler, rec.status)
+	})
+}
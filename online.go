@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	seatTokenLength  = 16
+	joinCodeLength   = 6
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	rejoinWindow     = time.Minute
+
+	eventConnect    = "connect"
+	eventDisconnect = "disconnect"
+	eventAbandoned  = "abandoned"
+)
+
+// onlineController is a paddle played from another browser. The player who
+// claims the seat gets its token and every input for the paddle has to come
+// with it. The match waits while a seat has no connection.
+type onlineController struct {
+	humanController
+	token       string
+	claimed     bool
+	connections int
+	// droppedAt is when the seat lost its last connection.
+	droppedAt time.Time
+}
+
+func (c *onlineController) Spec() string {
+	return controllerOnline
+}
+
+func newJoinCode() string {
+	b := make([]byte, joinCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// human returns the held input of a paddle steered by a person, local or
+// online, or nil for AI and bot paddles.
+func (g *GameState) human(side string) *humanController {
+	switch c := g.controller(side).(type) {
+	case *humanController:
+		return c
+	case *onlineController:
+		return &c.humanController
+	}
+	return nil
+}
+
+// seat finds the online seat token belongs to. The caller must hold g.mu.
+func (g *GameState) seat(token string) *onlineController {
+	if token == "" {
+		return nil
+	}
+	for _, c := range []Controller{g.left, g.right} {
+		if seat, ok := c.(*onlineController); ok && subtle.ConstantTimeCompare([]byte(token), []byte(seat.token)) == 1 {
+			return seat
+		}
+	}
+	return nil
+}
+
+// seated reports whether the match has online seats. Only their players
+// may control it then. The caller must hold g.mu.
+func (g *GameState) seated() bool {
+	_, left := g.left.(*onlineController)
+	_, right := g.right.(*onlineController)
+	return left || right
+}
+
+// allowsControl reports whether a request with token may pause, reset or
+// leave the match.
+func (g *GameState) allowsControl(token string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return !g.seated() || g.seat(token) != nil
+}
+
+func (g *GameState) hasSeats() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.seated()
+}
+
+// controlledRoom looks up the room for a request that changes the game. In
+// an online match the request has to carry one of the seat tokens.
+func controlledRoom(w http.ResponseWriter, r *http.Request) *Room {
+	room := roomFromRequest(w, r)
+	if room == nil {
+		return nil
+	}
+	if !room.Game.allowsControl(r.URL.Query().Get("token")) {
+		http.Error(w, "seat token required", http.StatusForbidden)
+		return nil
+	}
+	return room
+}
+
+// claimSeat hands the first unclaimed online seat to the player name, which
+// may be empty for anonymous players, and returns its side and token.
+func (g *GameState) claimSeat(name string) (string, string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.takeSeat(name)
+}
+
+// takeSeat is claimSeat for a caller that holds g.mu.
+func (g *GameState) takeSeat(name string) (string, string, bool) {
+	for _, side := range []string{"left", "right"} {
+		seat, ok := g.controller(side).(*onlineController)
+		if !ok || seat.claimed {
+			continue
+		}
+		seat.claimed = true
+		if side == "left" {
+			g.LeftPlayer = name
+		} else {
+			g.RightPlayer = name
+		}
+		return side, seat.token, true
+	}
+	return "", "", false
+}
+
+// moveSide resolves the paddle a client may move. A client holding a seat
+// token moves its own paddle, wherever it is after switching sides. Clients
+// without one may only move paddles of matches without online seats.
+func (g *GameState) moveSide(paddle, token string) (string, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if token != "" {
+		seat := g.seat(token)
+		if seat == nil {
+			return "", http.StatusForbidden
+		}
+		side := g.sideOf(seat)
+		if paddle != "" && paddle != side {
+			return "", http.StatusForbidden
+		}
+		return side, http.StatusOK
+	}
+	if g.seated() {
+		return "", http.StatusForbidden
+	}
+	return paddle, http.StatusOK
+}
+
+func (g *GameState) attachSeat(token string, now time.Time) (*onlineController, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seat := g.seat(token)
+	if seat == nil {
+		return nil, http.StatusForbidden
+	}
+	seat.connections++
+	if seat.connections == 1 {
+		g.emit(GameEvent{Type: eventConnect, Side: g.sideOf(seat)})
+		g.updateWaiting(now)
+	}
+	return seat, http.StatusOK
+}
+
+// detachSeat drops one of the seat's connections. When the last one goes
+// the paddle is released and a running match is paused until the player
+// rejoins or rejoinWindow runs out.
+func (g *GameState) detachSeat(seat *onlineController, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seat.connections--
+	if seat.connections > 0 || (g.left != seat && g.right != seat) {
+		return
+	}
+	seat.droppedAt = now
+	side := g.sideOf(seat)
+	g.releasePaddle(side)
+	if !g.Paused && !g.GameOver && !g.InMenu && g.Tick > 0 {
+		g.Paused = true
+		g.emit(GameEvent{Type: eventPause, Paused: true})
+	}
+	g.emit(GameEvent{Type: eventDisconnect, Side: side})
+	g.updateWaiting(now)
+}
+
+// updateWaiting holds the match while an online seat has no connection and
+// starts the rejoin countdown when a player drops out of a match under way.
+// The caller must hold g.mu.
+func (g *GameState) updateWaiting(now time.Time) {
+	waiting, away := false, false
+	for _, c := range []Controller{g.left, g.right} {
+		seat, ok := c.(*onlineController)
+		if !ok || seat.connections > 0 {
+			continue
+		}
+		waiting = true
+		if seat.claimed && g.Tick > 0 && !g.GameOver && !g.InMenu {
+			away = true
+		}
+	}
+	g.Waiting = waiting
+	switch {
+	case !away:
+		g.RejoinBy = 0
+	case g.RejoinBy == 0:
+		g.RejoinBy = now.Add(rejoinWindow).UnixMilli()
+	}
+}
+
+// expireSeats ends a match whose player did not come back in time. When
+// both players are away, the one who dropped out first left the match. The
+// match is recorded as unfinished, so it counts for neither side.
+func (g *GameState) expireSeats(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.RejoinBy == 0 || now.UnixMilli() < g.RejoinBy {
+		return
+	}
+	g.RejoinBy = 0
+	side := ""
+	var first time.Time
+	for _, candidate := range []string{"left", "right"} {
+		seat, ok := g.controller(candidate).(*onlineController)
+		if !ok || seat.connections > 0 {
+			continue
+		}
+		if side == "" || seat.droppedAt.Before(first) {
+			side, first = candidate, seat.droppedAt
+		}
+	}
+	if side == "" {
+		return
+	}
+	g.finishRecording()
+	g.GameOver = true
+	g.Winner = g.abandonMessage(side)
+	g.emit(GameEvent{Type: eventAbandoned, Side: side})
+}
+
+func (g *GameState) abandonMessage(side string) string {
+	if name := g.playerName(side); name != "" {
+		return name + " left the match"
+	}
+	return "Your opponent left the match"
+}
+
+func handleJoin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	room := rooms.byJoinCode(strings.ToUpper(strings.TrimSpace(req.Code)))
+	if room == nil {
+		http.Error(w, "no match with that code", http.StatusNotFound)
+		return
+	}
+	side, token, ok := room.Game.claimSeat(currentPlayer(r))
+	if !ok {
+		http.Error(w, "match is full", http.StatusConflict)
+		return
+	}
+	requestLogger(r).Info("player joined", "room", room.ID, "side", side)
+
+	writeJSON(w, r, struct {
+		Room  string `json:"room"`
+		Side  string `json:"side"`
+		Token string `json:"token"`
+	}{room.ID, side, token})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// onlineRoom registers a room with two online seats, claimed by ann on the
+// left and bob on the right, and returns it with their tokens.
+func onlineRoom(t *testing.T) (*Room, string, string) {
+	t.Helper()
+	saved := rooms
+	rooms = newRoomRegistry()
+	t.Cleanup(func() { rooms = saved })
+
+	room := rooms.create()
+	room.Game.setControllers(
+		&onlineController{token: randomHex(seatTokenLength)},
+		&onlineController{token: randomHex(seatTokenLength)},
+	)
+	_, left, _ := room.Game.claimSeat("ann")
+	_, right, _ := room.Game.claimSeat("bob")
+	return room, left, right
+}
+
+func serve(handler http.HandlerFunc, path string, query url.Values, body string) int {
+	r := httptest.NewRequest(http.MethodPost, path+"?"+query.Encode(), strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Code
+}
+
+func TestOnlineMatchRequiresSeatToken(t *testing.T) {
+	room, left, right := onlineRoom(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		query   url.Values
+		body    string
+		want    int
+	}{
+		{"move without token", handleMove, "/move", url.Values{"room": {room.ID}}, `{"paddle":"left","direction":"up"}`, http.StatusForbidden},
+		{"move without paddle or token", handleMove, "/move", url.Values{"room": {room.ID}}, `{"direction":"up"}`, http.StatusForbidden},
+		{"move with unknown token", handleMove, "/move", url.Values{"room": {room.ID}, "token": {"abc"}}, `{"direction":"up"}`, http.StatusForbidden},
+		{"move other seat", handleMove, "/move", url.Values{"room": {room.ID}, "token": {right}}, `{"paddle":"left","direction":"up"}`, http.StatusForbidden},
+		{"move own seat", handleMove, "/move", url.Values{"room": {room.ID}, "token": {left}}, `{"paddle":"left","direction":"up"}`, http.StatusOK},
+		{"move as spectator", handleMove, "/move", url.Values{"watch": {room.Game.WatchID}, "token": {left}}, `{"direction":"up"}`, http.StatusForbidden},
+		{"pause without token", handlePause, "/pause", url.Values{"room": {room.ID}}, "", http.StatusForbidden},
+		{"pause as spectator", handlePause, "/pause", url.Values{"watch": {room.Game.WatchID}}, "", http.StatusForbidden},
+		{"pause with seat token", handlePause, "/pause", url.Values{"room": {room.ID}, "token": {right}}, "", http.StatusOK},
+		{"reset without token", handleReset, "/reset", url.Values{"room": {room.ID}}, "", http.StatusForbidden},
+		{"menu without token", handleBackToMenu, "/menu", url.Values{"room": {room.ID}}, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.handler, tt.path, tt.query, tt.body); got != tt.want {
+				t.Errorf("status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// startOnlineMatch connects both seats and plays the match for a moment.
+func startOnlineMatch(t *testing.T, g *GameState, left, right string, now time.Time) (*onlineController, *onlineController) {
+	t.Helper()
+	leftSeat, status := g.attachSeat(left, now)
+	if status != http.StatusOK {
+		t.Fatalf("attaching left seat: status %d", status)
+	}
+	rightSeat, status := g.attachSeat(right, now)
+	if status != http.StatusOK {
+		t.Fatalf("attaching right seat: status %d", status)
+	}
+	g.InMenu = false
+	g.resetGame()
+	for range 10 {
+		g.update(physicsStep.Seconds())
+	}
+	if g.Tick == 0 {
+		t.Fatal("match did not start")
+	}
+	g.takeEvents()
+	return leftSeat, rightSeat
+}
+
+func abandonedSide(events []GameEvent) string {
+	for _, event := range events {
+		if event.Type == eventAbandoned {
+			return event.Side
+		}
+	}
+	return ""
+}
+
+func TestExpireSeatsAfterRejoinWindow(t *testing.T) {
+	room, left, right := onlineRoom(t)
+	g := room.Game
+	now := time.Now()
+	_, rightSeat := startOnlineMatch(t, g, left, right, now)
+
+	g.detachSeat(rightSeat, now)
+	g.expireSeats(now.Add(rejoinWindow - time.Second))
+	if g.GameOver {
+		t.Fatal("match ended before the rejoin window ran out")
+	}
+	g.expireSeats(now.Add(rejoinWindow))
+	if !g.GameOver || g.Winner != "bob left the match" {
+		t.Fatalf("game over %v, winner %q; want bob to have left", g.GameOver, g.Winner)
+	}
+	if side := abandonedSide(g.takeEvents()); side != "right" {
+		t.Errorf("abandoned by %q, want right", side)
+	}
+}
+
+func TestExpireSeatsRejoinInTime(t *testing.T) {
+	room, left, right := onlineRoom(t)
+	g := room.Game
+	now := time.Now()
+	_, rightSeat := startOnlineMatch(t, g, left, right, now)
+
+	g.detachSeat(rightSeat, now)
+	if _, status := g.attachSeat(right, now.Add(time.Second)); status != http.StatusOK {
+		t.Fatalf("rejoining: status %d", status)
+	}
+	g.expireSeats(now.Add(rejoinWindow))
+	if g.GameOver || g.RejoinBy != 0 {
+		t.Errorf("game over %v, rejoin by %d; want the match to go on", g.GameOver, g.RejoinBy)
+	}
+}
+
+func TestExpireSeatsBlamesFirstToLeave(t *testing.T) {
+	for _, first := range []string{"left", "right"} {
+		t.Run(first, func(t *testing.T) {
+			room, left, right := onlineRoom(t)
+			g := room.Game
+			now := time.Now()
+			leftSeat, rightSeat := startOnlineMatch(t, g, left, right, now)
+
+			earlier, later := leftSeat, rightSeat
+			if first == "right" {
+				earlier, later = rightSeat, leftSeat
+			}
+			g.detachSeat(earlier, now)
+			g.detachSeat(later, now.Add(10*time.Second))
+			g.expireSeats(now.Add(rejoinWindow))
+			if side := abandonedSide(g.takeEvents()); side != first {
+				t.Errorf("abandoned by %q, want %s, who left first", side, first)
+			}
+		})
+	}
+}
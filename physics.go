@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+)
+
+const (
+	maxCollisionPasses = 8
+	// contactEpsilon is the fraction of a step below which a hit counts as
+	// happening at once.
+	contactEpsilon = 1e-9
+	// contactSlop is the distance, in table units, below which the ball
+	// bouncing from one surface straight into another counts as wedged
+	// between them.
+	contactSlop = 1e-3
+)
+
+// PhysicsConfig holds the dimensions and speeds a match is played with.
+// Lengths are in table units and speeds in units per second.
+type PhysicsConfig struct {
+	TableWidth     float64 `json:"tableWidth"`
+	TableHeight    float64 `json:"tableHeight"`
+	PaddleWidth    float64 `json:"paddleWidth"`
+	PaddleHeight   float64 `json:"paddleHeight"`
+	BallRadius     float64 `json:"ballRadius"`
+	PaddleSpeed    float64 `json:"paddleSpeed"`
+	ServeSpeed     Vec2    `json:"serveSpeed"`
+	PaddleSpeedup  float64 `json:"paddleSpeedup"`
+	MaxBounceAngle float64 `json:"maxBounceAngleDeg"`
+}
+
+// standardPhysics is the table the game was designed around. defaultPhysics
+// starts out equal to it and can be replaced from a config file.
+var (
+	standardPhysics = PhysicsConfig{
+		TableWidth:     1200,
+		TableHeight:    600,
+		PaddleWidth:    20,
+		PaddleHeight:   120,
+		BallRadius:     10,
+		PaddleSpeed:    600,
+		ServeSpeed:     Vec2{X: 360, Y: 240},
+		PaddleSpeedup:  1.08,
+		MaxBounceAngle: 60,
+	}
+	defaultPhysics = standardPhysics
+)
+
+func (c PhysicsConfig) validate() error {
+	switch {
+	case c.TableWidth < 200 || c.TableWidth > 4000:
+		return errors.New("tableWidth must be between 200 and 4000")
+	case c.TableHeight < 100 || c.TableHeight > 2000:
+		return errors.New("tableHeight must be between 100 and 2000")
+	case c.PaddleWidth <= 0 || 2*c.PaddleWidth+4*c.BallRadius >= c.TableWidth:
+		return errors.New("paddleWidth must be positive and leave room for the ball between the paddles")
+	case c.PaddleHeight <= 0 || c.PaddleHeight >= c.TableHeight:
+		return errors.New("paddleHeight must be positive and smaller than tableHeight")
+	case c.BallRadius <= 0 || 2*c.BallRadius >= c.TableHeight:
+		return errors.New("ballRadius must be positive and fit on the table")
+	case c.PaddleSpeed <= 0 || c.PaddleSpeed > 10000:
+		return errors.New("paddleSpeed must be between 0 and 10000")
+	case c.ServeSpeed.X <= 0 || c.ServeSpeed.Y < 0 || math.Hypot(c.ServeSpeed.X, c.ServeSpeed.Y) > 5000:
+		return errors.New("serveSpeed must have a positive x, a non-negative y and a length of at most 5000")
+	case c.PaddleSpeedup < 1 || c.PaddleSpeedup > 2:
+		return errors.New("paddleSpeedup must be between 1 and 2")
+	case c.MaxBounceAngle <= 0 || c.MaxBounceAngle > 80:
+		return errors.New("maxBounceAngleDeg must be between 0 and 80")
+	}
+	return nil
+}
+
+func (c PhysicsConfig) maxBounceAngle() float64 {
+	return c.MaxBounceAngle * math.Pi / 180
+}
+
+// loadPhysicsConfig reads a PhysicsConfig from a JSON file. Fields missing
+// from the file keep their standard values.
+func loadPhysicsConfig(path string) (PhysicsConfig, error) {
+	config := standardPhysics
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	return config, config.validate()
+}
+
+func (g *GameState) setPhysics(config PhysicsConfig) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.usePhysics(config)
+}
+
+// usePhysics sizes the ball and paddles for config. The caller must hold
+// g.mu.
+func (g *GameState) usePhysics(config PhysicsConfig) {
+	g.Physics = config
+	g.Ball.Radius = config.BallRadius
+	for _, p := range []*Paddle{&g.LeftPaddle, &g.RightPaddle} {
+		p.Width = config.PaddleWidth
+		p.Height = config.PaddleHeight
+	}
+	g.centerPaddles()
+}
+
+func (g *GameState) centerPaddles() {
+	g.LeftPaddle.Y = g.Physics.TableHeight/2 - g.LeftPaddle.Height/2
+	g.RightPaddle.Y = g.Physics.TableHeight/2 - g.RightPaddle.Height/2
+}
+
+type collider int
+
+const (
+	colliderNone collider = iota
+	colliderWall
+	colliderLeftPaddle
+	colliderRightPaddle
+)
+
+type sweepHit struct {
+	t      float64
+	normal Vec2
+}
+
+type box struct {
+	minX, minY, maxX, maxY float64
+}
+
+// overlaps reports whether a circle of radius r at pos cuts into b.
+func (b box) overlaps(pos Vec2, r float64) bool {
+	dx := pos.X - math.Max(b.minX, math.Min(b.maxX, pos.X))
+	dy := pos.Y - math.Max(b.minY, math.Min(b.maxY, pos.Y))
+	return dx*dx+dy*dy < r*r-contactEpsilon
+}
+
+func (g *GameState) paddleBox(p Paddle, side string) box {
+	if side == "left" {
+		return box{minX: 0, minY: p.Y, maxX: p.Width, maxY: p.Y + p.Height}
+	}
+	width := g.Physics.TableWidth
+	return box{minX: width - p.Width, minY: p.Y, maxX: width, maxY: p.Y + p.Height}
+}
+
+// moveBall advances the ball by dt seconds, sweeping it against the walls and
+// both paddles so that no collision is skipped however fast the ball moves.
+func (g *GameState) moveBall(dt float64) {
+	g.separateBall()
+
+	remaining := 1.0
+	last := colliderNone
+	// pinned is the normal of two surfaces the ball touches at once, such
+	// as a wall and a paddle edge about one ball apart. The ball slides
+	// along them for the rest of the step instead of bouncing between them.
+	var pinned Vec2
+	for pass := 0; pass < maxCollisionPasses && remaining > 0; pass++ {
+		delta := Vec2{X: g.Ball.Vel.X * dt * remaining, Y: g.Ball.Vel.Y * dt * remaining}
+		if pinned != (Vec2{}) {
+			along := delta.X*pinned.X + delta.Y*pinned.Y
+			delta = Vec2{X: delta.X - along*pinned.X, Y: delta.Y - along*pinned.Y}
+		}
+		hit, target := g.firstCollision(delta, last)
+		if target == colliderNone {
+			g.Ball.Pos.X += delta.X
+			g.Ball.Pos.Y += delta.Y
+			return
+		}
+		if last != colliderNone && hit.t*math.Hypot(delta.X, delta.Y) < contactSlop {
+			pinned = hit.normal
+		}
+
+		g.Ball.Pos.X += delta.X * hit.t
+		g.Ball.Pos.Y += delta.Y * hit.t
+		remaining *= 1 - hit.t
+		last = target
+
+		switch target {
+		case colliderWall:
+			g.Ball.Vel = reflect(g.Ball.Vel, hit.normal)
+		case colliderLeftPaddle:
+			g.bounceOffPaddle(g.LeftPaddle, hit.normal, 1)
+		case colliderRightPaddle:
+			g.bounceOffPaddle(g.RightPaddle, hit.normal, -1)
+		}
+	}
+}
+
+// firstCollision finds the first surface the ball meets along delta. A hit
+// at once against last, the surface the ball was just reflected from, is
+// ignored: the ball is leaving it.
+func (g *GameState) firstCollision(delta Vec2, last collider) (sweepHit, collider) {
+	best := sweepHit{t: math.Inf(1)}
+	target := colliderNone
+	consider := func(hit sweepHit, ok bool, c collider) {
+		if ok && hit.t < best.t && (c != last || hit.t >= contactEpsilon) {
+			best, target = hit, c
+		}
+	}
+
+	hit, ok := sweepWalls(g.Ball.Pos, delta, g.Ball.Radius, g.Physics.TableHeight)
+	consider(hit, ok, colliderWall)
+	hit, ok = sweepCircleBox(g.Ball.Pos, delta, g.Ball.Radius, g.paddleBox(g.LeftPaddle, "left"))
+	consider(hit, ok, colliderLeftPaddle)
+	hit, ok = sweepCircleBox(g.Ball.Pos, delta, g.Ball.Radius, g.paddleBox(g.RightPaddle, "right"))
+	consider(hit, ok, colliderRightPaddle)
+	return best, target
+}
+
+// separateBall pushes the ball out of a paddle that moved into it, keeping
+// it between the walls. A ball with no room between the paddle and a wall
+// is pushed sideways out of the paddle's reach instead.
+func (g *GameState) separateBall() {
+	r := g.Ball.Radius
+	for _, b := range []box{g.paddleBox(g.LeftPaddle, "left"), g.paddleBox(g.RightPaddle, "right")} {
+		if !b.overlaps(g.Ball.Pos, r) {
+			continue
+		}
+		pos := g.Ball.Pos
+		closest := Vec2{X: math.Max(b.minX, math.Min(b.maxX, pos.X)), Y: math.Max(b.minY, math.Min(b.maxY, pos.Y))}
+		if d := math.Hypot(pos.X-closest.X, pos.Y-closest.Y); d > 0 {
+			pos.X = closest.X + (pos.X-closest.X)*r/d
+			pos.Y = closest.Y + (pos.Y-closest.Y)*r/d
+		} else {
+			// The centre is inside the paddle: take the shortest way out.
+			exits := []Vec2{
+				{X: b.minX - r, Y: pos.Y},
+				{X: b.maxX + r, Y: pos.Y},
+				{X: pos.X, Y: b.minY - r},
+				{X: pos.X, Y: b.maxY + r},
+			}
+			best := math.Inf(1)
+			for _, exit := range exits {
+				if d := math.Hypot(exit.X-pos.X, exit.Y-pos.Y); d < best {
+					best, pos = d, exit
+				}
+			}
+		}
+		pos.Y = math.Max(r, math.Min(g.Physics.TableHeight-r, pos.Y))
+		if b.overlaps(pos, r) {
+			if pos.X-b.minX < b.maxX-pos.X {
+				pos.X = b.minX - r
+			} else {
+				pos.X = b.maxX + r
+			}
+		}
+		g.Ball.Pos = pos
+	}
+}
+
+// bounceOffPaddle returns the ball into the court when it strikes the paddle
+// face or the corner facing the court, and reflects it off the paddle's top or
+// bottom edge otherwise. courtSide is +1 for the left paddle and -1 for the right.
+func (g *GameState) bounceOffPaddle(p Paddle, normal Vec2, courtSide float64) {
+	if normal.X*courtSide <= 0 {
+		g.Ball.Vel = reflect(g.Ball.Vel, normal)
+		return
+	}
+
+	relativeY := (g.Ball.Pos.Y - (p.Y + p.Height/2)) / (p.Height / 2)
+	relativeY = math.Max(-1, math.Min(1, relativeY))
+	angle := relativeY * g.Physics.maxBounceAngle()
+	speed := math.Hypot(g.Ball.Vel.X, g.Ball.Vel.Y) * g.Physics.PaddleSpeedup
+	g.Ball.Vel.X = courtSide * speed * math.Cos(angle)
+	g.Ball.Vel.Y = speed * math.Sin(angle)
+	g.Rally++
+}
+
+func reflect(v, normal Vec2) Vec2 {
+	dot := v.X*normal.X + v.Y*normal.Y
+	return Vec2{X: v.X - 2*dot*normal.X, Y: v.Y - 2*dot*normal.Y}
+}
+
+func sweepWalls(pos, delta Vec2, r, height float64) (sweepHit, bool) {
+	if delta.Y < 0 && pos.Y-r >= 0 {
+		if t := (r - pos.Y) / delta.Y; t <= 1 {
+			return sweepHit{t: t, normal: Vec2{Y: 1}}, true
+		}
+	}
+	if delta.Y > 0 && pos.Y+r <= height {
+		if t := (height - r - pos.Y) / delta.Y; t <= 1 {
+			return sweepHit{t: t, normal: Vec2{Y: -1}}, true
+		}
+	}
+	return sweepHit{}, false
+}
+
+// sweepCircleBox finds the fraction of delta at which a circle of radius r
+// starting at pos first touches b. The box is expanded by r into a rounded
+// rectangle: four straight faces plus a circle of radius r at each corner.
+func sweepCircleBox(pos, delta Vec2, r float64, b box) (sweepHit, bool) {
+	best := sweepHit{t: math.Inf(1)}
+	found := false
+	consider := func(hit sweepHit, ok bool) {
+		if ok && hit.t < best.t {
+			best, found = hit, true
+		}
+	}
+
+	consider(sweepFace(pos.X, delta.X, pos.Y, delta.Y, b.minX-r, -1, b.minY, b.maxY))
+	consider(sweepFace(pos.X, delta.X, pos.Y, delta.Y, b.maxX+r, 1, b.minY, b.maxY))
+	if hit, ok := sweepFace(pos.Y, delta.Y, pos.X, delta.X, b.minY-r, -1, b.minX, b.maxX); ok {
+		consider(sweepHit{t: hit.t, normal: Vec2{Y: hit.normal.X}}, true)
+	}
+	if hit, ok := sweepFace(pos.Y, delta.Y, pos.X, delta.X, b.maxY+r, 1, b.minX, b.maxX); ok {
+		consider(sweepHit{t: hit.t, normal: Vec2{Y: hit.normal.X}}, true)
+	}
+
+	// A corner only counts where the ball meets it from outside both
+	// faces; a grazing touch within a face's span belongs to that face.
+	for _, corner := range []struct{ at, out Vec2 }{
+		{Vec2{X: b.minX, Y: b.minY}, Vec2{X: -1, Y: -1}},
+		{Vec2{X: b.maxX, Y: b.minY}, Vec2{X: 1, Y: -1}},
+		{Vec2{X: b.minX, Y: b.maxY}, Vec2{X: -1, Y: 1}},
+		{Vec2{X: b.maxX, Y: b.maxY}, Vec2{X: 1, Y: 1}},
+	} {
+		hit, ok := sweepCorner(pos, delta, r, corner.at)
+		consider(hit, ok && hit.normal.X*corner.out.X >= 0 && hit.normal.Y*corner.out.Y >= 0)
+	}
+	return best, found
+}
+
+// sweepFace intersects motion along one axis with a face at position face whose
+// outward normal along that axis is sign; the crossing must land within
+// [spanMin, spanMax] on the other axis. The returned normal is on the first axis.
+func sweepFace(p, d, q, dq, face, sign, spanMin, spanMax float64) (sweepHit, bool) {
+	if d*sign >= 0 || (p-face)*sign < 0 {
+		return sweepHit{}, false
+	}
+	t := (face - p) / d
+	if t < 0 || t > 1 {
+		return sweepHit{}, false
+	}
+	if at := q + dq*t; at < spanMin || at > spanMax {
+		return sweepHit{}, false
+	}
+	return sweepHit{t: t, normal: Vec2{X: sign}}, true
+}
+
+func sweepCorner(pos, delta Vec2, r float64, corner Vec2) (sweepHit, bool) {
+	mx, my := pos.X-corner.X, pos.Y-corner.Y
+	a := delta.X*delta.X + delta.Y*delta.Y
+	b := 2 * (mx*delta.X + my*delta.Y)
+	c := mx*mx + my*my - r*r
+	if a == 0 || c < 0 || b >= 0 {
+		return sweepHit{}, false
+	}
+	disc := b*b - 4*a*c
+	if disc < 0 {
+		return sweepHit{}, false
+	}
+	t := (-b - math.Sqrt(disc)) / (2 * a)
+	if t < 0 || t > 1 {
+		return sweepHit{}, false
+	}
+	nx, ny := mx+delta.X*t, my+delta.Y*t
+	length := math.Hypot(nx, ny)
+	return sweepHit{t: t, normal: Vec2{X: nx / length, Y: ny / length}}, true
+}
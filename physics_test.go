@@ -0,0 +1,208 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+const epsilon = 1e-9
+
+// tableGame returns a game on the standard table with the paddles centred,
+// at y 240 to 360, and the ball placed by the caller.
+func tableGame(pos, vel Vec2) *GameState {
+	g := newSeededGame(1)
+	g.setPhysics(standardPhysics)
+	g.Ball = Ball{Pos: pos, Vel: vel, Radius: standardPhysics.BallRadius}
+	return g
+}
+
+// stepUntilReturned moves the ball until it turns back along x, failing if
+// it gets past either paddle on the way.
+func stepUntilReturned(t *testing.T, g *GameState) {
+	t.Helper()
+	left := g.Physics.PaddleWidth + g.Ball.Radius
+	right := g.Physics.TableWidth - left
+	direction := g.Ball.Vel.X
+	for step := 0; step < 60; step++ {
+		g.moveBall(physicsStep.Seconds())
+		if g.Ball.Pos.X < left-epsilon || g.Ball.Pos.X > right+epsilon {
+			t.Fatalf("step %d: ball at x %v went through the paddle", step, g.Ball.Pos.X)
+		}
+		if g.Ball.Vel.X*direction < 0 {
+			return
+		}
+	}
+	t.Fatalf("ball never came back, velocity %v", g.Ball.Vel)
+}
+
+func TestMoveBallDoesNotTunnelThroughPaddles(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  Vec2
+		vel  Vec2
+	}{
+		{"left 2000", Vec2{X: 300, Y: 300}, Vec2{X: -2000}},
+		{"left 5000", Vec2{X: 300, Y: 300}, Vec2{X: -5000}},
+		{"left 20000", Vec2{X: 300, Y: 300}, Vec2{X: -20000}},
+		{"left diagonal", Vec2{X: 400, Y: 200}, Vec2{X: -6000, Y: 1500}},
+		{"right 5000", Vec2{X: 900, Y: 300}, Vec2{X: 5000}},
+		{"right 20000", Vec2{X: 900, Y: 300}, Vec2{X: 20000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tableGame(tt.pos, tt.vel)
+			stepUntilReturned(t, g)
+			if g.Rally != 1 {
+				t.Errorf("rally %d, want 1", g.Rally)
+			}
+		})
+	}
+}
+
+func TestMoveBallReflectsOffPaddleTopEdge(t *testing.T) {
+	// The ball drops straight onto the top edge of the left paddle.
+	g := tableGame(Vec2{X: 10, Y: 200}, Vec2{Y: 3000})
+	g.moveBall(physicsStep.Seconds())
+
+	if g.Ball.Vel != (Vec2{Y: -3000}) {
+		t.Errorf("velocity %v, want {0 -3000}", g.Ball.Vel)
+	}
+	if top := g.LeftPaddle.Y - g.Ball.Radius; g.Ball.Pos.Y > top+epsilon {
+		t.Errorf("ball at y %v overlaps the paddle, whose top is at %v", g.Ball.Pos.Y, g.LeftPaddle.Y)
+	}
+	if g.Rally != 0 {
+		t.Errorf("rally %d, want 0 for an edge hit", g.Rally)
+	}
+}
+
+func TestMoveBallReturnsCornerHitsIntoCourt(t *testing.T) {
+	// The ball comes in at 45 degrees onto the left paddle's top corner
+	// facing the court, at x 20, y 240.
+	g := tableGame(Vec2{X: 120, Y: 140}, Vec2{X: -3000, Y: 3000})
+	stepUntilReturned(t, g)
+
+	if g.Rally != 1 {
+		t.Errorf("rally %d, want 1", g.Rally)
+	}
+	corner := Vec2{X: g.LeftPaddle.Width, Y: g.LeftPaddle.Y}
+	if d := math.Hypot(g.Ball.Pos.X-corner.X, g.Ball.Pos.Y-corner.Y); d < g.Ball.Radius-epsilon {
+		t.Errorf("ball %v from the corner, want at least its radius", d)
+	}
+	speed := math.Hypot(g.Ball.Vel.X, g.Ball.Vel.Y)
+	want := math.Hypot(3000, 3000) * standardPhysics.PaddleSpeedup
+	if math.Abs(speed-want) > 1e-6 {
+		t.Errorf("speed %v, want %v", speed, want)
+	}
+}
+
+func TestMoveBallSlidesOutBetweenWallAndPaddleEdge(t *testing.T) {
+	// The left paddle's top edge sits a hair more than one ball from the
+	// top wall, and the ball is wedged in the gap heading for the goal.
+	tests := []struct {
+		name string
+		pos  Vec2
+		vel  Vec2
+	}{
+		{"mid edge", Vec2{X: 15.36, Y: 10}, Vec2{X: -900, Y: -300}},
+		{"glancing", Vec2{X: 11.703365943848304, Y: 10.00001839999921}, Vec2{X: -1174.3054754657082, Y: -74.85967914768662}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tableGame(tt.pos, tt.vel)
+			g.LeftPaddle.Y = 2*g.Ball.Radius + 0.00002
+			for step := 0; step < 10; step++ {
+				g.moveBall(physicsStep.Seconds())
+			}
+			if g.Ball.Pos.X > -g.Ball.Radius {
+				t.Errorf("ball at %v after ten steps, want it past the paddle", g.Ball.Pos)
+			}
+		})
+	}
+}
+
+func TestMoveBallPushesOutOfMovingPaddle(t *testing.T) {
+	// The paddle has moved up over the ball resting against the top wall.
+	g := tableGame(Vec2{X: 15, Y: 10}, Vec2{X: -600})
+	g.LeftPaddle.Y = 15
+	g.moveBall(physicsStep.Seconds())
+
+	if g.paddleBox(g.LeftPaddle, "left").overlaps(g.Ball.Pos, g.Ball.Radius) {
+		t.Errorf("ball at %v overlaps the paddle at y %v", g.Ball.Pos, g.LeftPaddle.Y)
+	}
+	if g.Ball.Pos.Y < g.Ball.Radius-epsilon {
+		t.Errorf("ball at y %v is through the top wall", g.Ball.Pos.Y)
+	}
+}
+
+func TestMoveBallReflectsOffWalls(t *testing.T) {
+	g := tableGame(Vec2{X: 600, Y: 40}, Vec2{X: 100, Y: -6000})
+	g.moveBall(physicsStep.Seconds())
+
+	if g.Ball.Vel != (Vec2{X: 100, Y: 6000}) {
+		t.Errorf("velocity %v, want {100 6000}", g.Ball.Vel)
+	}
+	if g.Ball.Pos.Y < g.Ball.Radius-epsilon {
+		t.Errorf("ball at y %v is through the top wall", g.Ball.Pos.Y)
+	}
+}
+
+func TestSweepCorner(t *testing.T) {
+	tests := []struct {
+		name   string
+		pos    Vec2
+		delta  Vec2
+		t      float64
+		normal Vec2
+		hit    bool
+	}{
+		{"head on", Vec2{X: -100}, Vec2{X: 200}, 0.45, Vec2{X: -1}, true},
+		{"glancing", Vec2{X: -100, Y: 6}, Vec2{X: 200}, 0.46, Vec2{X: -0.8, Y: 0.6}, true},
+		{"miss", Vec2{X: -100, Y: 11}, Vec2{X: 200}, 0, Vec2{}, false},
+		{"moving away", Vec2{X: -20}, Vec2{X: -200}, 0, Vec2{}, false},
+		{"too short", Vec2{X: -100}, Vec2{X: 50}, 0, Vec2{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hit, ok := sweepCorner(tt.pos, tt.delta, 10, Vec2{})
+			if ok != tt.hit {
+				t.Fatalf("hit %v, want %v", ok, tt.hit)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(hit.t-tt.t) > epsilon {
+				t.Errorf("t %v, want %v", hit.t, tt.t)
+			}
+			if math.Abs(hit.normal.X-tt.normal.X) > epsilon || math.Abs(hit.normal.Y-tt.normal.Y) > epsilon {
+				t.Errorf("normal %v, want %v", hit.normal, tt.normal)
+			}
+		})
+	}
+}
+
+func TestSweepWalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		pos    Vec2
+		delta  Vec2
+		t      float64
+		normal Vec2
+		hit    bool
+	}{
+		{"top", Vec2{Y: 15}, Vec2{Y: -20}, 0.25, Vec2{Y: 1}, true},
+		{"bottom", Vec2{Y: 580}, Vec2{Y: 40}, 0.25, Vec2{Y: -1}, true},
+		{"short of the wall", Vec2{Y: 300}, Vec2{Y: -20}, 0, Vec2{}, false},
+		{"level", Vec2{Y: 10}, Vec2{X: 50}, 0, Vec2{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hit, ok := sweepWalls(tt.pos, tt.delta, 10, 600)
+			if ok != tt.hit {
+				t.Fatalf("hit %v, want %v", ok, tt.hit)
+			}
+			if ok && (math.Abs(hit.t-tt.t) > epsilon || hit.normal != tt.normal) {
+				t.Errorf("hit %+v, want t %v normal %v", hit, tt.t, tt.normal)
+			}
+		})
+	}
+}
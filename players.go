@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPlayersPath = "players.json"
+	sessionCookieName  = "pp_session"
+	sessionLifetime    = 30 * 24 * time.Hour
+	sessionTokenLength = 32
+	loginTokenLength   = 12
+	saltLength         = 16
+	passwordIterations = 100_000
+	passwordKeyLength  = 32
+	minPasswordLength  = 6
+)
+
+var (
+	players     *PlayerStore
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _.-]{0,23}$`)
+
+	errNameTaken        = errors.New("name already registered")
+	errInvalidName      = errors.New("names are 1-24 letters, digits, spaces, dots, dashes or underscores")
+	errShortPassword    = errors.New("password must be at least 6 characters")
+	errInvalidLogin     = errors.New("invalid name or password")
+	errNotAuthenticated = errors.New("not signed in")
+	errUnknownPlayer    = errors.New("player not found")
+)
+
+type Account struct {
+	Name      string    `json:"name"`
+	Salt      []byte    `json:"salt"`
+	Hash      []byte    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type session struct {
+	Name    string    `json:"name"`
+	Expires time.Time `json:"expires"`
+}
+
+// PlayerStore holds registered accounts and their browser sessions. Session
+// tokens are stored hashed so the file on disk cannot be used to sign in.
+type PlayerStore struct {
+	mu       sync.Mutex
+	path     string
+	Accounts map[string]*Account `json:"accounts"`
+	Sessions map[string]session  `json:"sessions"`
+}
+
+func openPlayerStore(path string) (*PlayerStore, error) {
+	store := &PlayerStore{
+		path:     path,
+		Accounts: make(map[string]*Account),
+		Sessions: make(map[string]session),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, err
+	}
+	if store.Accounts == nil {
+		store.Accounts = make(map[string]*Account)
+	}
+	if store.Sessions == nil {
+		store.Sessions = make(map[string]session)
+	}
+	return store, nil
+}
+
+func (s *PlayerStore) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0o600)
+}
+
+func accountKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func hashSecret(secret string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, secret, salt, passwordIterations, passwordKeyLength)
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// register creates an account. When password is empty a random login token
+// is generated and returned; it is the only way to sign in again later.
+func (s *PlayerStore) register(name, password string) (string, error) {
+	name = strings.TrimSpace(name)
+	if !namePattern.MatchString(name) {
+		return "", errInvalidName
+	}
+	secret, token := password, ""
+	if secret == "" {
+		token = randomHex(loginTokenLength)
+		secret = token
+	} else if len(secret) < minPasswordLength {
+		return "", errShortPassword
+	}
+
+	salt := []byte(randomHex(saltLength))
+	hash, err := hashSecret(secret, salt)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := accountKey(name)
+	if _, ok := s.Accounts[key]; ok {
+		return "", errNameTaken
+	}
+	s.Accounts[key] = &Account{Name: name, Salt: salt, Hash: hash, CreatedAt: time.Now()}
+	if err := s.save(); err != nil {
+		delete(s.Accounts, key)
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *PlayerStore) lookup(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.Accounts[accountKey(strings.TrimSpace(name))]
+	if !ok {
+		return "", errUnknownPlayer
+	}
+	return account.Name, nil
+}
+
+func (s *PlayerStore) authenticate(name, secret string) (string, error) {
+	s.mu.Lock()
+	account, ok := s.Accounts[accountKey(strings.TrimSpace(name))]
+	s.mu.Unlock()
+	if !ok {
+		return "", errInvalidLogin
+	}
+	hash, err := hashSecret(secret, account.Salt)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare(hash, account.Hash) != 1 {
+		return "", errInvalidLogin
+	}
+	return account.Name, nil
+}
+
+func (s *PlayerStore) createSession(name string) (string, time.Time, error) {
+	token := randomHex(sessionTokenLength)
+	expires := time.Now().Add(sessionLifetime)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, sess := range s.Sessions {
+		if now.After(sess.Expires) {
+			delete(s.Sessions, key)
+		}
+	}
+	s.Sessions[hashSessionToken(token)] = session{Name: name, Expires: expires}
+	return token, expires, s.save()
+}
+
+func (s *PlayerStore) endSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.Sessions, hashSessionToken(token))
+	return s.save()
+}
+
+func (s *PlayerStore) sessionPlayer(token string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.Sessions[hashSessionToken(token)]
+	if !ok || time.Now().After(sess.Expires) {
+		return ""
+	}
+	return sess.Name
+}
+
+func currentPlayer(r *http.Request) string {
+	if players == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return players.sessionPlayer(cookie.Value)
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+type credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+func writePlayer(w http.ResponseWriter, r *http.Request, name, token string) {
+	writeJSON(w, r, struct {
+		Name  string `json:"name"`
+		Token string `json:"token,omitempty"`
+	}{name, token})
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := players.register(req.Name, req.Password)
+	switch {
+	case errors.Is(err, errNameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errInvalidName), errors.Is(err, errShortPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	sessionToken, expires, err := players.createSession(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, sessionToken, expires)
+	writePlayer(w, r, name, token)
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret := req.Password
+	if secret == "" {
+		secret = req.Token
+	}
+	name, err := players.authenticate(req.Name, secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	sessionToken, expires, err := players.createSession(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, r, sessionToken, expires)
+	writePlayer(w, r, name, "")
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := players.endSession(cookie.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	name := currentPlayer(r)
+	if name == "" {
+		http.Error(w, errNotAuthenticated.Error(), http.StatusUnauthorized)
+		return
+	}
+	writePlayer(w, r, name, "")
+}
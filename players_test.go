@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPlayerStore(t *testing.T) *PlayerStore {
+	t.Helper()
+	store, err := openPlayerStore(filepath.Join(t.TempDir(), "players.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{"Ann", "secret1", nil},
+		{"aNN", "secret2", errNameTaken},
+		{" ann ", "secret3", errNameTaken},
+		{"Bob", "12345", errShortPassword},
+		{"", "secret4", errInvalidName},
+		{"-dash", "secret5", errInvalidName},
+		{strings.Repeat("a", 25), "secret6", errInvalidName},
+	}
+	store := newTestPlayerStore(t)
+	for _, tt := range tests {
+		if _, err := store.register(tt.name, tt.password); !errors.Is(err, tt.err) {
+			t.Errorf("register(%q, %q): err %v, want %v", tt.name, tt.password, err, tt.err)
+		}
+	}
+	if len(store.Accounts) != 1 {
+		t.Errorf("%d accounts, want only ann's", len(store.Accounts))
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := newTestPlayerStore(t)
+	if token, err := store.register("Ann", "secret1"); err != nil || token != "" {
+		t.Fatalf("register with a password: token %q, err %v; want no token", token, err)
+	}
+	token, err := store.register("Bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 2*loginTokenLength {
+		t.Fatalf("login token %q, want %d hex digits", token, 2*loginTokenLength)
+	}
+
+	tests := []struct {
+		name, secret string
+		want         string
+		err          error
+	}{
+		{"ann", "secret1", "Ann", nil},
+		{"Ann", "secret2", "", errInvalidLogin},
+		{"Bob", token, "Bob", nil},
+		{"BOB", token, "Bob", nil},
+		{"Bob", "", "", errInvalidLogin},
+		{"cat", "secret1", "", errInvalidLogin},
+	}
+	for _, tt := range tests {
+		got, err := store.authenticate(tt.name, tt.secret)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("authenticate(%q, %q) = %q, %v; want %q, %v", tt.name, tt.secret, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "players.json")
+	store, err := openPlayerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.register("Ann", "secret1")
+	token, expires, err := store.createSession("Ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expires); until < sessionLifetime-time.Minute || until > sessionLifetime {
+		t.Errorf("session expires in %v, want %v", until, sessionLifetime)
+	}
+	if got := store.sessionPlayer(token); got != "Ann" {
+		t.Errorf("session player %q, want Ann", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Error("session token stored in the clear")
+	}
+	reopened, err := openPlayerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.sessionPlayer(token); got != "Ann" {
+		t.Errorf("session player %q after reopening, want Ann", got)
+	}
+
+	if err := store.endSession(token); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.sessionPlayer(token); got != "" {
+		t.Errorf("session player %q after signing out, want none", got)
+	}
+}
+
+func TestExpiredSessionRejected(t *testing.T) {
+	store := newTestPlayerStore(t)
+	store.register("Ann", "secret1")
+	token, _, err := store.createSession("Ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := hashSessionToken(token)
+	store.Sessions[key] = session{Name: "Ann", Expires: time.Now().Add(-time.Second)}
+
+	if got := store.sessionPlayer(token); got != "" {
+		t.Errorf("expired session signs in %q, want nobody", got)
+	}
+	// Creating another session clears out the expired one.
+	store.createSession("Ann")
+	if _, ok := store.Sessions[key]; ok {
+		t.Error("expired session kept")
+	}
+}
+
+func TestLoginWithGeneratedToken(t *testing.T) {
+	saved := players
+	players = newTestPlayerStore(t)
+	t.Cleanup(func() { players = saved })
+
+	w := httptest.NewRecorder()
+	handleRegister(w, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"name":"Ann"}`)))
+	var registered struct{ Name, Token string }
+	if err := json.NewDecoder(w.Body).Decode(&registered); err != nil || registered.Token == "" {
+		t.Fatalf("register: status %d, response %+v, err %v; want a login token", w.Code, registered, err)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"name":"ann","token":"` + registered.Token + `"}`, http.StatusOK},
+		{`{"name":"ann","token":"0123456789abcdef01234567"}`, http.StatusUnauthorized},
+		{`{"name":"ann"}`, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleLogin(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("login %s: status %d, want %d", tt.body, w.Code, tt.want)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		if got := currentPlayer(r); got != "Ann" {
+			t.Errorf("signed in as %q, want Ann", got)
+		}
+	}
+}
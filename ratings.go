@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRatingsPath = "ratings.json"
+	initialRating      = 1200
+	eloK               = 32
+	defaultBoardSize   = 10
+	maxBoardSize       = 100
+)
+
+// aiAnchorRatings are fixed ratings for the built-in AI. Playing against the
+// AI moves the human's rating but never the anchor's.
+var aiAnchorRatings = map[string]float64{
+	"easy":   1000,
+	"medium": 1300,
+	"hard":   1600,
+}
+
+var ratings *RatingStore
+
+type RatingChange struct {
+	MatchID  string    `json:"matchId"`
+	At       time.Time `json:"at"`
+	Opponent string    `json:"opponent"`
+	Won      bool      `json:"won"`
+	Before   float64   `json:"before"`
+	After    float64   `json:"after"`
+}
+
+type PlayerRating struct {
+	Name    string         `json:"name"`
+	Rating  float64        `json:"rating"`
+	Games   int            `json:"games"`
+	Wins    int            `json:"wins"`
+	Losses  int            `json:"losses"`
+	Anchor  bool           `json:"anchor,omitempty"`
+	History []RatingChange `json:"history,omitempty"`
+}
+
+type RatingStore struct {
+	mu      sync.Mutex
+	path    string
+	Players map[string]*PlayerRating `json:"players"`
+}
+
+func openRatingStore(path string) (*RatingStore, error) {
+	store := &RatingStore{path: path, Players: make(map[string]*PlayerRating)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, err
+	}
+	if store.Players == nil {
+		store.Players = make(map[string]*PlayerRating)
+	}
+	return store, nil
+}
+
+func (s *RatingStore) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0o644)
+}
+
+func aiAnchorName(difficulty string) string {
+	return "Computer (" + difficulty + ")"
+}
+
+func anchorEntries() []*PlayerRating {
+	entries := make([]*PlayerRating, 0, len(aiAnchorRatings))
+	for difficulty, rating := range aiAnchorRatings {
+		entries = append(entries, &PlayerRating{Name: aiAnchorName(difficulty), Rating: rating, Anchor: true})
+	}
+	return entries
+}
+
+// participant resolves one side of a recorded match to a rated entry. Named
+// players get their stored (or initial) rating; built-in AI gets its anchor.
+// Anonymous humans and bots are not rated.
+func (s *RatingStore) participant(name, controller string) (*PlayerRating, bool) {
+	if name != "" {
+		key := accountKey(name)
+		if p, ok := s.Players[key]; ok {
+			return p, true
+		}
+		return &PlayerRating{Name: name, Rating: initialRating}, true
+	}
+	kind, difficulty, _ := strings.Cut(controller, ":")
+	if rating, ok := aiAnchorRatings[difficulty]; ok && kind == controllerAI {
+		return &PlayerRating{Name: aiAnchorName(difficulty), Rating: rating, Anchor: true}, true
+	}
+	return nil, false
+}
+
+func expectedScore(rating, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+func (s *RatingStore) recordMatch(rec *Recording) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	left, ok := s.participant(rec.LeftPlayer, rec.LeftController)
+	if !ok {
+		return nil
+	}
+	right, ok := s.participant(rec.RightPlayer, rec.RightController)
+	if !ok || (left.Anchor && right.Anchor) || accountKey(left.Name) == accountKey(right.Name) {
+		return nil
+	}
+
+	leftWon := rec.leftWon()
+	leftBefore, rightBefore := left.Rating, right.Rating
+	s.apply(left, right.Name, leftBefore, rightBefore, leftWon, rec)
+	s.apply(right, left.Name, rightBefore, leftBefore, !leftWon, rec)
+	return s.save()
+}
+
+func (s *RatingStore) apply(p *PlayerRating, opponent string, rating, opponentRating float64, won bool, rec *Recording) {
+	if p.Anchor {
+		return
+	}
+	actual := 0.0
+	if won {
+		actual = 1
+		p.Wins++
+	} else {
+		p.Losses++
+	}
+	p.Games++
+	p.Rating = rating + eloK*(actual-expectedScore(rating, opponentRating))
+	p.History = append(p.History, RatingChange{
+		MatchID:  rec.ID,
+		At:       rec.EndedAt,
+		Opponent: opponent,
+		Won:      won,
+		Before:   rating,
+		After:    p.Rating,
+	})
+	s.Players[accountKey(p.Name)] = p
+}
+
+func (s *RatingStore) leaderboard(limit int) []PlayerRating {
+	s.mu.Lock()
+	entries := anchorEntries()
+	for _, p := range s.Players {
+		entries = append(entries, p)
+	}
+	board := make([]PlayerRating, 0, len(entries))
+	for _, p := range entries {
+		entry := *p
+		entry.History = nil
+		board = append(board, entry)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(board, func(a, b PlayerRating) int {
+		if a.Rating != b.Rating {
+			if a.Rating > b.Rating {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(board) > limit {
+		board = board[:limit]
+	}
+	return board
+}
+
+func (s *RatingStore) lookup(name string) (PlayerRating, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.Players[accountKey(name)]
+	if !ok {
+		return PlayerRating{}, false
+	}
+	entry := *p
+	entry.History = slices.Clone(p.History)
+	return entry, true
+}
+
+// recordRatings rates a completed match. Matches played with physics other
+// than the server's defaults are not rated, since a faster paddle is an easy
+// way to beat the AI.
+func recordRatings(rec *Recording) {
+	if ratings == nil || !rec.Completed || rec.physics() != defaultPhysics {
+		return
+	}
+	if err := ratings.recordMatch(rec); err != nil {
+		slog.Error("updating ratings", "match", rec.ID, "err", err)
+	}
+}
+
+func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultBoardSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, r, ratings.leaderboard(min(max(limit, 1), maxBoardSize)))
+}
+
+func handlePlayerRating(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	rating, ok := ratings.lookup(name)
+	if !ok {
+		account, err := players.lookup(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		rating = PlayerRating{Name: account, Rating: initialRating}
+	}
+	writeJSON(w, r, rating)
+}
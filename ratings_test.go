@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpectedScore(t *testing.T) {
+	tests := []struct {
+		rating, opponent, want float64
+	}{
+		{1200, 1200, 0.5},
+		{1600, 1200, 10.0 / 11},
+		{1200, 1600, 1.0 / 11},
+	}
+	for _, tt := range tests {
+		if got := expectedScore(tt.rating, tt.opponent); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("expectedScore(%v, %v) = %v, want %v", tt.rating, tt.opponent, got, tt.want)
+		}
+	}
+	if sum := expectedScore(1350, 1720) + expectedScore(1720, 1350); math.Abs(sum-1) > 1e-12 {
+		t.Errorf("expected scores of both sides add up to %v, want 1", sum)
+	}
+}
+
+func newTestRatingStore(t *testing.T) *RatingStore {
+	t.Helper()
+	store, err := openRatingStore(filepath.Join(t.TempDir(), "ratings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func ratedMatch(leftPlayer, leftController, rightPlayer, rightController string, leftWon bool) *Recording {
+	rec := &Recording{
+		ID:              "m1",
+		LeftPlayer:      leftPlayer,
+		LeftController:  leftController,
+		RightPlayer:     rightPlayer,
+		RightController: rightController,
+		Completed:       true,
+		RightGames:      1,
+	}
+	if leftWon {
+		rec.LeftGames, rec.RightGames = 1, 0
+	}
+	return rec
+}
+
+func TestRecordMatchAgainstAnchor(t *testing.T) {
+	store := newTestRatingStore(t)
+	if err := store.recordMatch(ratedMatch("Ann", controllerHuman, "", controllerAI+":hard", true)); err != nil {
+		t.Fatal(err)
+	}
+
+	ann, ok := store.lookup("ann")
+	if !ok {
+		t.Fatal("ann was not rated")
+	}
+	want := initialRating + eloK*(1-expectedScore(initialRating, aiAnchorRatings["hard"]))
+	if math.Abs(ann.Rating-want) > 1e-9 || ann.Wins != 1 || ann.Games != 1 {
+		t.Errorf("ann %+v, want rating %v after one win", ann, want)
+	}
+	if len(ann.History) != 1 || ann.History[0].Opponent != aiAnchorName("hard") {
+		t.Errorf("history %+v, want one match against the hard AI", ann.History)
+	}
+	if _, ok := store.lookup(aiAnchorName("hard")); ok {
+		t.Error("the anchor was stored as a player")
+	}
+
+	// The anchor keeps its rating however often it loses.
+	for range 5 {
+		store.recordMatch(ratedMatch("", controllerAI+":hard", "Bob", controllerHuman, false))
+	}
+	for _, entry := range store.leaderboard(maxBoardSize) {
+		if entry.Name == aiAnchorName("hard") && entry.Rating != aiAnchorRatings["hard"] {
+			t.Errorf("hard AI rated %v, want its anchor %v", entry.Rating, aiAnchorRatings["hard"])
+		}
+	}
+}
+
+func TestRecordMatchBetweenPlayers(t *testing.T) {
+	store := newTestRatingStore(t)
+	store.recordMatch(ratedMatch("Ann", controllerHuman, "Bob", controllerHuman, false))
+
+	ann, _ := store.lookup("Ann")
+	bob, _ := store.lookup("Bob")
+	if ann.Rating != initialRating-eloK/2 || bob.Rating != initialRating+eloK/2 {
+		t.Errorf("ratings ann %v, bob %v; want %v and %v", ann.Rating, bob.Rating, initialRating-eloK/2, initialRating+eloK/2)
+	}
+}
+
+func TestRecordMatchSkipsUnratedMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  *Recording
+	}{
+		{"anonymous human", ratedMatch("", controllerHuman, "", controllerAI+":easy", true)},
+		{"bot", ratedMatch("Ann", controllerHuman, "", controllerBot, true)},
+		{"two anchors", ratedMatch("", controllerAI+":easy", "", controllerAI+":hard", true)},
+		{"against oneself", ratedMatch("Ann", controllerHuman, "ann", controllerHuman, true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestRatingStore(t)
+			if err := store.recordMatch(tt.rec); err != nil {
+				t.Fatal(err)
+			}
+			if len(store.Players) != 0 {
+				t.Errorf("players %v, want none rated", store.Players)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRecordingsDir = "recordings"
+	matchIDLength        = 8
+
+	inputHold   = "hold"
+	inputTarget = "target"
+	inputAxis   = "axis"
+	// inputMove is a single step from before paddles kept their input;
+	// older recordings still contain it.
+	inputMove = "move"
+)
+
+var (
+	recordingsDir  = defaultRecordingsDir
+	matchIDPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+	// archiving tracks matches being archived in the background, so that
+	// shutdown can wait for them before the stores close.
+	archiving sync.WaitGroup
+)
+
+type Recording struct {
+	ID              string          `json:"id"`
+	Seed            int64           `json:"seed"`
+	GameMode        string          `json:"gameMode"`
+	Difficulty      string          `json:"difficulty"`
+	Rules           MatchRules      `json:"rules"`
+	Physics         PhysicsConfig   `json:"physics"`
+	MaxScore        int             `json:"maxScore,omitempty"`
+	LeftController  string          `json:"leftController"`
+	RightController string          `json:"rightController"`
+	LeftPlayer      string          `json:"leftPlayer"`
+	RightPlayer     string          `json:"rightPlayer"`
+	StartedAt       time.Time       `json:"startedAt"`
+	EndedAt         time.Time       `json:"endedAt"`
+	Ticks           uint64          `json:"ticks"`
+	Completed       bool            `json:"completed"`
+	LeftScore       int             `json:"leftScore"`
+	RightScore      int             `json:"rightScore"`
+	LeftGames       int             `json:"leftGames"`
+	RightGames      int             `json:"rightGames"`
+	Winner          string          `json:"winner"`
+	Inputs          []RecordedInput `json:"inputs"`
+	Events          []RecordedEvent `json:"events"`
+}
+
+type RecordedInput struct {
+	Tick      uint64  `json:"tick"`
+	AtMs      int64   `json:"atMs"`
+	Side      string  `json:"side"`
+	Action    string  `json:"action"`
+	Direction string  `json:"direction"`
+	Value     float64 `json:"value,omitempty"`
+}
+
+func (i RecordedInput) held() heldInput {
+	return heldInput{action: i.Action, direction: i.Direction, value: i.Value}
+}
+
+type RecordedEvent struct {
+	Tick uint64 `json:"tick"`
+	AtMs int64  `json:"atMs"`
+	GameEvent
+}
+
+func (g *GameState) startRecording() {
+	if !g.recordMatches || g.InMenu {
+		g.recording = nil
+		return
+	}
+	g.MatchID = randomHex(matchIDLength)
+	g.recording = &Recording{
+		ID:              g.MatchID,
+		Seed:            g.Seed,
+		GameMode:        g.GameMode,
+		Difficulty:      g.Difficulty,
+		Rules:           g.Rules,
+		Physics:         g.Physics,
+		LeftController:  g.LeftController,
+		RightController: g.RightController,
+		LeftPlayer:      g.LeftPlayer,
+		RightPlayer:     g.RightPlayer,
+		StartedAt:       time.Now(),
+	}
+}
+
+// finishRecording closes the current recording and queues it for archiving.
+// Matches that never got past the first tick are dropped. Scores are stored
+// from the point of view of the sides the match started on.
+func (g *GameState) finishRecording() {
+	rec := g.recording
+	g.recording = nil
+	if rec == nil || g.Tick == 0 {
+		return
+	}
+	rec.EndedAt = time.Now()
+	rec.Ticks = g.Tick
+	rec.Completed = g.GameOver
+	rec.LeftScore, rec.RightScore = g.LeftScore, g.RightScore
+	rec.LeftGames, rec.RightGames = g.LeftGames, g.RightGames
+	if g.SidesSwitched {
+		rec.LeftScore, rec.RightScore = rec.RightScore, rec.LeftScore
+		rec.LeftGames, rec.RightGames = rec.RightGames, rec.LeftGames
+	}
+	rec.Winner = g.Winner
+	g.archive = append(g.archive, rec)
+}
+
+// endRecording finishes the recording of a game that is going away, so the
+// match is archived as it stands.
+func (g *GameState) endRecording() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.finishRecording()
+}
+
+func (g *GameState) takeArchive() []*Recording {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	archive := g.archive
+	g.archive = nil
+	return archive
+}
+
+func (g *GameState) recordInput(side string, in heldInput) {
+	if g.recording == nil || g.GameOver {
+		return
+	}
+	g.recording.Inputs = append(g.recording.Inputs, RecordedInput{
+		Tick:      g.Tick,
+		AtMs:      time.Since(g.recording.StartedAt).Milliseconds(),
+		Side:      side,
+		Action:    in.action,
+		Direction: in.direction,
+		Value:     in.value,
+	})
+}
+
+func (g *GameState) recordEvent(event GameEvent) {
+	if g.recording == nil {
+		return
+	}
+	switch event.Type {
+	case eventPoint, eventGame, eventGameOver:
+		// The recording already carries the match ID.
+		event.MatchID = ""
+		g.recording.Events = append(g.recording.Events, RecordedEvent{
+			Tick:      g.Tick,
+			AtMs:      time.Since(g.recording.StartedAt).Milliseconds(),
+			GameEvent: event,
+		})
+	}
+}
+
+// archiveInBackground archives rec without holding up the game loop.
+func archiveInBackground(rec *Recording) {
+	archiving.Go(func() { archiveMatch(rec) })
+}
+
+func archiveMatch(rec *Recording) {
+	if err := saveRecording(rec); err != nil {
+		slog.Error("saving recording", "match", rec.ID, "err", err)
+	}
+	if !rec.Completed {
+		return
+	}
+	metrics.observeMatch(rec)
+	if matchStore != nil {
+		if err := matchStore.Add(matchRecordFrom(rec)); err != nil {
+			slog.Error("saving match to history", "match", rec.ID, "err", err)
+		}
+	}
+	recordRatings(rec)
+}
+
+func recordingPath(id string) (string, error) {
+	if !matchIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid match id %q", id)
+	}
+	return filepath.Join(recordingsDir, id+".json"), nil
+}
+
+func saveRecording(rec *Recording) error {
+	path, err := recordingPath(rec.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers and a crash mid-write never see half a file.
+// The data is synced before the rename and the directory after it, so the
+// new file survives a power loss once this returns. The directory is
+// created if needed.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func loadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// leftWon reports whether the side that started on the left won the match.
+func (rec *Recording) leftWon() bool {
+	if rec.LeftGames != rec.RightGames {
+		return rec.LeftGames > rec.RightGames
+	}
+	return rec.LeftScore > rec.RightScore
+}
+
+// rules returns the match rules, falling back to a single game to MaxScore
+// for recordings made before rules were configurable.
+func (rec *Recording) rules() MatchRules {
+	if rec.Rules.PointsToWin == 0 && rec.MaxScore != 0 {
+		return MatchRules{PointsToWin: rec.MaxScore, BestOf: 1}
+	}
+	return rec.Rules.withDefaults()
+}
+
+// physics returns the physics settings, falling back to the standard table
+// for recordings made before they were configurable.
+func (rec *Recording) physics() PhysicsConfig {
+	if rec.Physics == (PhysicsConfig{}) {
+		return standardPhysics
+	}
+	return rec.Physics
+}
+
+func replayController(spec string) (Controller, error) {
+	c, err := parseController(spec)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.(*aiController); ok {
+		return c, nil
+	}
+	return &humanController{}, nil
+}
+
+type ReplayResult struct {
+	Recording  *Recording      `json:"recording"`
+	Ticks      uint64          `json:"ticks"`
+	LeftScore  int             `json:"leftScore"`
+	RightScore int             `json:"rightScore"`
+	LeftGames  int             `json:"leftGames"`
+	RightGames int             `json:"rightGames"`
+	Winner     string          `json:"winner"`
+	Events     []RecordedEvent `json:"events"`
+	Matches    bool            `json:"matches"`
+	State      *GameState      `json:"state,omitempty"`
+}
+
+// replay re-simulates rec through GameState.update, feeding recorded inputs
+// back in at the tick they were made. If stopAt is non-zero the replay stops
+// after that many ticks and the state at that point is included.
+func replay(rec *Recording, stopAt uint64) (*ReplayResult, error) {
+	left, err := replayController(rec.LeftController)
+	if err != nil {
+		return nil, err
+	}
+	right, err := replayController(rec.RightController)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newSeededGame(rec.Seed)
+	g.setPhysics(rec.physics())
+	g.setControllers(left, right)
+	g.setPlayers(rec.LeftPlayer, rec.RightPlayer)
+	g.GameMode = rec.GameMode
+	g.Difficulty = rec.Difficulty
+	g.Rules = rec.rules()
+	g.InMenu = false
+	g.resetGame()
+
+	last := rec.Ticks
+	if stopAt != 0 && stopAt < last {
+		last = stopAt
+	}
+
+	result := &ReplayResult{Recording: rec}
+	dt := physicsStep.Seconds()
+	next := 0
+	for g.Tick < last && !g.GameOver {
+		for ; next < len(rec.Inputs) && rec.Inputs[next].Tick <= g.Tick; next++ {
+			input := rec.Inputs[next]
+			switch input.Action {
+			case inputHold, inputTarget, inputAxis:
+				g.holdPaddle(input.Side, input.held(), 0)
+			case inputMove:
+				g.movePaddle(input.Side, input.Direction)
+			}
+		}
+		g.update(dt)
+		for _, event := range g.takeEvents() {
+			switch event.Type {
+			case eventPoint, eventGame, eventGameOver:
+				result.Events = append(result.Events, RecordedEvent{Tick: g.Tick, GameEvent: event})
+			}
+		}
+	}
+
+	result.Ticks = g.Tick
+	result.LeftScore, result.RightScore = g.LeftScore, g.RightScore
+	result.LeftGames, result.RightGames = g.LeftGames, g.RightGames
+	if g.SidesSwitched {
+		result.LeftScore, result.RightScore = result.RightScore, result.LeftScore
+		result.LeftGames, result.RightGames = result.RightGames, result.LeftGames
+	}
+	result.Winner = g.Winner
+	result.Matches = g.Tick == rec.Ticks && result.LeftScore == rec.LeftScore &&
+		result.RightScore == rec.RightScore && result.LeftGames == rec.LeftGames &&
+		result.RightGames == rec.RightGames && g.GameOver == rec.Completed
+	if stopAt != 0 {
+		result.Matches = false
+		result.State = g
+	}
+	return result, nil
+}
+
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	path, err := recordingPath(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := loadRecording(path)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var stopAt uint64
+	if tick := r.URL.Query().Get("tick"); tick != "" {
+		stopAt, err = strconv.ParseUint(tick, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid tick", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := replay(rec, stopAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, r, result)
+}
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dir := fs.String("dir", defaultRecordingsDir, "directory containing match recordings")
+	stopAt := fs.Uint64("tick", 0, "stop after this many ticks and print the game state")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [-dir dir] [-tick n] <match id or file>")
+	}
+
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		recordingsDir = *dir
+		if path, err = recordingPath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	rec, err := loadRecording(path)
+	if err != nil {
+		return err
+	}
+
+	result, err := replay(rec, *stopAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Match:      %s (seed %d, %s vs %s)\n", rec.ID, rec.Seed, rec.LeftController, rec.RightController)
+	fmt.Printf("Recorded:   games %d : %d, points %d : %d after %d ticks\n", rec.LeftGames, rec.RightGames, rec.LeftScore, rec.RightScore, rec.Ticks)
+	for _, event := range result.Events {
+		at := time.Duration(event.Tick) * physicsStep
+		switch event.Type {
+		case eventPoint:
+			fmt.Printf("  %10s  point %-5s  %2d : %-2d  rally %d\n", at.Round(time.Millisecond), event.Scorer, event.LeftScore, event.RightScore, event.Rally)
+		case eventGame:
+			fmt.Printf("  %10s  game  %-5s  games %d : %d\n", at.Round(time.Millisecond), event.Scorer, event.LeftGames, event.RightGames)
+		case eventGameOver:
+			fmt.Printf("  %10s  game over: %s\n", at.Round(time.Millisecond), event.Winner)
+		}
+	}
+	fmt.Printf("Replayed:   games %d : %d, points %d : %d after %d ticks\n", result.LeftGames, result.RightGames, result.LeftScore, result.RightScore, result.Ticks)
+
+	if result.State != nil {
+		data, err := json.MarshalIndent(result.State, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if !result.Matches {
+		return errors.New("replay diverged from the recording")
+	}
+	fmt.Println("Replay matches the recording")
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// playRecordedMatch plays a match between a human following the ball and
+// the hard AI, with every kind of input, and returns its recording as it
+// would be read back from disk.
+func playRecordedMatch(t *testing.T) *Recording {
+	t.Helper()
+	human := &humanController{}
+	g := newSeededGame(42)
+	g.recordMatches = true
+	g.setControllers(human, &aiController{difficulty: "hard"})
+	g.Rules = MatchRules{PointsToWin: 3, BestOf: 3, SwitchSides: true}
+	g.InMenu = false
+	g.resetGame()
+
+	dt := physicsStep.Seconds()
+	for tick := 0; tick < 100000 && !g.GameOver; tick++ {
+		side := g.sideOf(human)
+		switch {
+		case tick%90 == 0:
+			g.holdPaddle(side, heldInput{action: inputTarget, value: g.Ball.Pos.Y}, 0)
+		case tick%90 == 30:
+			axis := -0.6
+			if g.Ball.Pos.Y > g.paddle(side).Y {
+				axis = 0.6
+			}
+			g.holdPaddle(side, heldInput{action: inputAxis, value: axis}, 0)
+		case tick%90 == 60:
+			g.holdPaddle(side, holdInput("down"), 0)
+		case tick%90 == 75:
+			g.holdPaddle(side, holdInput(""), 0)
+		}
+		g.update(dt)
+		g.takeEvents()
+	}
+	if !g.GameOver {
+		t.Fatal("match did not finish")
+	}
+
+	archive := g.takeArchive()
+	if len(archive) != 1 {
+		t.Fatalf("archived %d recordings, want 1", len(archive))
+	}
+	data, err := json.Marshal(archive[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	actions := make(map[string]bool)
+	for _, input := range rec.Inputs {
+		actions[input.Action] = true
+	}
+	if !actions[inputHold] || !actions[inputTarget] || !actions[inputAxis] {
+		t.Fatalf("recorded actions %v, want hold, target and axis", actions)
+	}
+	return &rec
+}
+
+func TestReplayReproducesMatch(t *testing.T) {
+	rec := playRecordedMatch(t)
+	result, err := replay(rec, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Matches {
+		t.Fatalf("replay ended at tick %d with games %d-%d, score %d-%d; recording ended at tick %d with games %d-%d, score %d-%d",
+			result.Ticks, result.LeftGames, result.RightGames, result.LeftScore, result.RightScore,
+			rec.Ticks, rec.LeftGames, rec.RightGames, rec.LeftScore, rec.RightScore)
+	}
+	if len(result.Events) != len(rec.Events) {
+		t.Fatalf("replay has %d events, recording %d", len(result.Events), len(rec.Events))
+	}
+	for i, event := range result.Events {
+		want := rec.Events[i]
+		if event.Tick != want.Tick || event.Type != want.Type || event.Scorer != want.Scorer {
+			t.Errorf("event %d: %s by %s at tick %d, recorded %s by %s at tick %d",
+				i, event.Type, event.Scorer, event.Tick, want.Type, want.Scorer, want.Tick)
+		}
+	}
+}
+
+func TestReplayStopsAtTick(t *testing.T) {
+	rec := playRecordedMatch(t)
+	first, err := replay(rec, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := replay(rec, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.State == nil || first.Ticks != 500 {
+		t.Fatalf("replay stopped at tick %d with state %v, want the state at tick 500", first.Ticks, first.State)
+	}
+	if first.State.Ball != second.State.Ball || first.State.LeftPaddle != second.State.LeftPaddle ||
+		first.State.RightPaddle != second.State.RightPaddle {
+		t.Errorf("two replays to tick 500 differ: %+v and %+v", first.State.Ball, second.State.Ball)
+	}
+}
+
+func TestReplayStepsLegacyMoves(t *testing.T) {
+	rec := &Recording{
+		Seed:            7,
+		GameMode:        "2player",
+		LeftController:  controllerHuman,
+		RightController: controllerHuman,
+		Ticks:           100,
+	}
+	for tick := uint64(0); tick < 10; tick++ {
+		rec.Inputs = append(rec.Inputs, RecordedInput{Tick: tick, Side: "left", Action: inputMove, Direction: "up"})
+	}
+	result, err := replay(rec, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := standardPhysics.PaddleSpeed * physicsStep.Seconds()
+	want := (standardPhysics.TableHeight-standardPhysics.PaddleHeight)/2 - 10*step
+	if got := result.State.LeftPaddle.Y; got < want-1e-6 || got > want+1e-6 {
+		t.Errorf("left paddle at %v after ten moves up, want %v", got, want)
+	}
+}
+
+func TestReapArchivesUnfinishedMatch(t *testing.T) {
+	saved := recordingsDir
+	recordingsDir = t.TempDir()
+	t.Cleanup(func() { recordingsDir = saved })
+
+	registry := newRoomRegistry()
+	room := registry.create()
+	g := room.Game
+	g.InMenu = false
+	g.resetGame()
+	for range 10 {
+		g.update(physicsStep.Seconds())
+	}
+	id := g.MatchID
+
+	registry.reap(time.Now().Add(roomIdleTimeout + time.Second))
+	archiving.Wait()
+	if registry.get(room.ID) != nil {
+		t.Fatal("idle room was not reaped")
+	}
+	path, err := recordingPath(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := loadRecording(path)
+	if err != nil {
+		t.Fatalf("loading the reaped match: %v", err)
+	}
+	if rec.Completed || rec.Ticks != 10 {
+		t.Errorf("recording completed %v after %d ticks, want unfinished after 10", rec.Completed, rec.Ticks)
+	}
+}
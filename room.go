@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	roomIdleTimeout    = 10 * time.Minute
+	roomReapInterval   = 30 * time.Second
+	roomIDLength       = 8
+	roomQueryParameter = "room"
+	maxPendingEvents   = 64
+)
+
+type Room struct {
+	ID       string
+	Game     *GameState
+	mu       sync.Mutex
+	lastSeen time.Time
+	clients  map[*roomSubscriber]struct{}
+}
+
+type roomSubscriber struct {
+	mu     sync.Mutex
+	notify chan struct{}
+	state  []byte
+	events []GameEvent
+}
+
+func (s *roomSubscriber) push(state []byte, events []GameEvent) {
+	s.mu.Lock()
+	s.state = state
+	s.events = append(s.events, events...)
+	if len(s.events) > maxPendingEvents {
+		s.events = s.events[len(s.events)-maxPendingEvents:]
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *roomSubscriber) drain() ([]byte, []GameEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, events := s.state, s.events
+	s.state, s.events = nil, nil
+	return state, events
+}
+
+func (r *Room) touch() {
+	r.mu.Lock()
+	r.lastSeen = time.Now()
+	r.mu.Unlock()
+}
+
+func (r *Room) subscribe() *roomSubscriber {
+	sub := &roomSubscriber{notify: make(chan struct{}, 1)}
+	r.mu.Lock()
+	if r.clients == nil {
+		r.clients = make(map[*roomSubscriber]struct{})
+	}
+	r.clients[sub] = struct{}{}
+	r.lastSeen = time.Now()
+	r.mu.Unlock()
+	return sub
+}
+
+func (r *Room) unsubscribe(sub *roomSubscriber) {
+	r.mu.Lock()
+	delete(r.clients, sub)
+	r.lastSeen = time.Now()
+	r.mu.Unlock()
+}
+
+func (r *Room) broadcast() {
+	events := r.Game.takeEvents()
+	metrics.observeEvents(events)
+	r.logEvents(events)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.clients) == 0 {
+		return
+	}
+	r.lastSeen = time.Now()
+
+	r.Game.mu.Lock()
+	snapshot, err := json.Marshal(r.Game)
+	r.Game.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	for sub := range r.clients {
+		sub.push(snapshot, events)
+	}
+}
+
+func (r *Room) clientCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+func (r *Room) idleSince() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastSeen
+}
+
+type RoomRegistry struct {
+	mu     sync.RWMutex
+	rooms  map[string]*Room
+	codes  map[string]*Room
+	watch  map[string]*Room
+	closed bool
+}
+
+var rooms *RoomRegistry
+
+func newRoomRegistry() *RoomRegistry {
+	return &RoomRegistry{
+		rooms: make(map[string]*Room),
+		codes: make(map[string]*Room),
+		watch: make(map[string]*Room),
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newRoomID() string {
+	return randomHex(roomIDLength)
+}
+
+// create makes a new room, or returns nil once the registry is closed.
+func (rr *RoomRegistry) create() *Room {
+	room := &Room{
+		ID:       newRoomID(),
+		Game:     newGame(),
+		lastSeen: time.Now(),
+	}
+	room.Game.recordMatches = true
+	room.Game.WatchID = newRoomID()
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.closed {
+		return nil
+	}
+	rr.rooms[room.ID] = room
+	rr.watch[room.Game.WatchID] = room
+	return room
+}
+
+func (rr *RoomRegistry) add(room *Room) {
+	if room.Game.WatchID == "" {
+		room.Game.WatchID = newRoomID()
+	}
+	rr.mu.Lock()
+	rr.rooms[room.ID] = room
+	rr.watch[room.Game.WatchID] = room
+	if code := room.Game.JoinCode; code != "" {
+		rr.codes[code] = room
+	}
+	rr.mu.Unlock()
+}
+
+// joinCode returns the code other players use to join room, making one up
+// the first time it is asked for.
+func (rr *RoomRegistry) joinCode(room *Room) string {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	room.Game.mu.Lock()
+	defer room.Game.mu.Unlock()
+
+	if room.Game.JoinCode == "" {
+		code := newJoinCode()
+		for rr.codes[code] != nil {
+			code = newJoinCode()
+		}
+		room.Game.JoinCode = code
+		rr.codes[code] = room
+	}
+	return room.Game.JoinCode
+}
+
+func (rr *RoomRegistry) byWatchID(id string) *Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	return rr.watch[id]
+}
+
+func (rr *RoomRegistry) byJoinCode(code string) *Room {
+	rr.mu.RLock()
+	room := rr.codes[code]
+	rr.mu.RUnlock()
+	if room != nil {
+		room.touch()
+	}
+	return room
+}
+
+// close stops the registry from creating rooms during shutdown.
+func (rr *RoomRegistry) close() {
+	rr.mu.Lock()
+	rr.closed = true
+	rr.mu.Unlock()
+}
+
+func (rr *RoomRegistry) get(id string) *Room {
+	rr.mu.RLock()
+	room := rr.rooms[id]
+	rr.mu.RUnlock()
+	if room != nil {
+		room.touch()
+	}
+	return room
+}
+
+func (rr *RoomRegistry) active() []*Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	list := make([]*Room, 0, len(rr.rooms))
+	for _, room := range rr.rooms {
+		list = append(list, room)
+	}
+	return list
+}
+
+func (rr *RoomRegistry) reap(now time.Time) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for id, room := range rr.rooms {
+		if now.Sub(room.idleSince()) > roomIdleTimeout {
+			room.Game.endRecording()
+			for _, rec := range room.Game.takeArchive() {
+				archiveInBackground(rec)
+			}
+			delete(rr.rooms, id)
+			delete(rr.codes, room.Game.JoinCode)
+			delete(rr.watch, room.Game.WatchID)
+		}
+	}
+}
+
+func roomFromRequest(w http.ResponseWriter, r *http.Request) *Room {
+	if spectating(r) {
+		forbidSpectator(w)
+		return nil
+	}
+	id := r.URL.Query().Get(roomQueryParameter)
+	if id == "" {
+		http.Error(w, "missing room", http.StatusBadRequest)
+		return nil
+	}
+	room := rooms.get(id)
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return nil
+	}
+	return room
+}
@@ -0,0 +1,114 @@
+package main
+
+import "fmt"
+
+const (
+	maxPointsToWin = 99
+	maxBestOf      = 9
+)
+
+// MatchRules decides when a game and the match are over. A match is a series
+// of games; the first side to win more than half of BestOf games wins it.
+type MatchRules struct {
+	PointsToWin int  `json:"pointsToWin"`
+	WinByTwo    bool `json:"winByTwo"`
+	BestOf      int  `json:"bestOf"`
+	SwitchSides bool `json:"switchSides"`
+}
+
+var defaultRules = MatchRules{PointsToWin: 11, BestOf: 1}
+
+func (r MatchRules) validate() error {
+	if r.PointsToWin < 1 || r.PointsToWin > maxPointsToWin {
+		return fmt.Errorf("pointsToWin must be between 1 and %d", maxPointsToWin)
+	}
+	if r.BestOf < 1 || r.BestOf > maxBestOf || r.BestOf%2 == 0 {
+		return fmt.Errorf("bestOf must be an odd number between 1 and %d", maxBestOf)
+	}
+	return nil
+}
+
+// withDefaults fills in fields left at zero in a request.
+func (r MatchRules) withDefaults() MatchRules {
+	if r.PointsToWin == 0 {
+		r.PointsToWin = defaultRules.PointsToWin
+	}
+	if r.BestOf == 0 {
+		r.BestOf = defaultRules.BestOf
+	}
+	return r
+}
+
+// gameWon reports whether a side with score points has won the current game
+// against an opponent with other points. With WinByTwo the game goes to deuce
+// until one side leads by two.
+func (r MatchRules) gameWon(score, other int) bool {
+	if score < r.PointsToWin {
+		return false
+	}
+	return !r.WinByTwo || score-other >= 2
+}
+
+func (r MatchRules) gamesToWin() int {
+	return r.BestOf/2 + 1
+}
+
+func (g *GameState) games(side string) *int {
+	if side == "left" {
+		return &g.LeftGames
+	}
+	return &g.RightGames
+}
+
+func (g *GameState) score(side string) *int {
+	if side == "left" {
+		return &g.LeftScore
+	}
+	return &g.RightScore
+}
+
+// scorePoint awards a point to side and settles the game and match.
+func (g *GameState) scorePoint(side string) {
+	other := opponent(side)
+	*g.score(side)++
+	g.emit(GameEvent{Type: eventPoint, Scorer: side, Rally: g.Rally})
+	if !g.Rules.gameWon(*g.score(side), *g.score(other)) {
+		g.resetPoint()
+		return
+	}
+
+	*g.games(side)++
+	if *g.games(side) >= g.Rules.gamesToWin() {
+		g.GameOver = true
+		g.Winner = g.winnerMessage(side)
+		g.emit(GameEvent{Type: eventGameOver, Scorer: side, Winner: g.Winner})
+		g.finishRecording()
+		return
+	}
+
+	g.emit(GameEvent{Type: eventGame, Scorer: side})
+	g.LeftScore, g.RightScore = 0, 0
+	g.GameNumber++
+	if g.Rules.SwitchSides {
+		g.switchSides()
+	}
+	g.resetPoint()
+}
+
+// switchSides swaps everything that belongs to a participant rather than a
+// paddle: the controller, the player name and the games won.
+func (g *GameState) switchSides() {
+	g.left, g.right = g.right, g.left
+	g.LeftController, g.RightController = g.RightController, g.LeftController
+	g.LeftPlayer, g.RightPlayer = g.RightPlayer, g.LeftPlayer
+	g.LeftGames, g.RightGames = g.RightGames, g.LeftGames
+	g.LeftAck, g.RightAck = g.RightAck, g.LeftAck
+	g.SidesSwitched = !g.SidesSwitched
+}
+
+func opponent(side string) string {
+	if side == "left" {
+		return "right"
+	}
+	return "left"
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestGameWon(t *testing.T) {
+	tests := []struct {
+		name         string
+		winByTwo     bool
+		score, other int
+		want         bool
+	}{
+		{"short of the points", false, 10, 3, false},
+		{"reached the points", false, 11, 10, true},
+		{"well clear", false, 11, 0, true},
+		{"two clear", true, 11, 9, true},
+		{"one clear at deuce", true, 11, 10, false},
+		{"level at deuce", true, 12, 12, false},
+		{"two clear after deuce", true, 14, 12, true},
+		{"two clear short of the points", true, 5, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := MatchRules{PointsToWin: 11, WinByTwo: tt.winByTwo, BestOf: 1}
+			if got := rules.gameWon(tt.score, tt.other); got != tt.want {
+				t.Errorf("gameWon(%d, %d) = %v, want %v", tt.score, tt.other, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGamesToWin(t *testing.T) {
+	for bestOf, want := range map[int]int{1: 1, 3: 2, 5: 3, 9: 5} {
+		if got := (MatchRules{BestOf: bestOf}).gamesToWin(); got != want {
+			t.Errorf("best of %d: gamesToWin() = %d, want %d", bestOf, got, want)
+		}
+	}
+}
+
+func TestScorePointPlaysDeuceUntilTwoClear(t *testing.T) {
+	g := newSeededGame(1)
+	g.Rules = MatchRules{PointsToWin: 3, WinByTwo: true, BestOf: 3}
+
+	for _, side := range []string{"left", "left", "right", "right", "left", "right", "left"} {
+		g.scorePoint(side)
+		if g.LeftGames != 0 || g.RightGames != 0 {
+			t.Fatalf("game won at %d-%d, want deuce to go on", g.LeftScore, g.RightScore)
+		}
+	}
+	g.scorePoint("left")
+	if g.LeftGames != 1 || g.LeftScore != 0 || g.RightScore != 0 || g.GameNumber != 2 {
+		t.Errorf("after 5-3: games %d-%d, score %d-%d, game %d; want games 1-0, score 0-0, game 2",
+			g.LeftGames, g.RightGames, g.LeftScore, g.RightScore, g.GameNumber)
+	}
+	if g.GameOver {
+		t.Error("match over after one game of best of three")
+	}
+}
+
+func TestScorePointSwitchesSidesAndEndsMatch(t *testing.T) {
+	g := newSeededGame(1)
+	g.Rules = MatchRules{PointsToWin: 1, BestOf: 3, SwitchSides: true}
+	g.LeftPlayer, g.RightPlayer = "ann", "bob"
+	human := g.left
+
+	g.scorePoint("left")
+	if !g.SidesSwitched || g.right != human || g.RightPlayer != "ann" || g.RightGames != 1 {
+		t.Fatalf("after game one: switched %v, right player %q, right games %d; want ann on the right with one game",
+			g.SidesSwitched, g.RightPlayer, g.RightGames)
+	}
+	g.scorePoint("right")
+	if !g.GameOver || g.Winner != "ann Wins!" {
+		t.Errorf("game over %v, winner %q; want ann to win", g.GameOver, g.Winner)
+	}
+}
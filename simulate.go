@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxMatchDuration = 30 * time.Minute
+
+type matchResult struct {
+	winner         string
+	points         int
+	rallyHits      int
+	pointDurations []time.Duration
+}
+
+type simulationSummary struct {
+	matches        int
+	leftWins       int
+	rightWins      int
+	unfinished     int
+	points         int
+	rallyHits      int
+	pointDurations []time.Duration
+}
+
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	matches := fs.Int("n", 1000, "number of matches to simulate")
+	leftSpec := fs.String("left", "hard", "left paddle AI difficulty")
+	rightSpec := fs.String("right", "medium", "right paddle AI difficulty")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "seed of the first match; match i uses seed+i")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of matches simulated in parallel")
+	maxDuration := fs.Duration("max-duration", defaultMaxMatchDuration, "game time after which a match is abandoned")
+	rules := defaultRules
+	fs.IntVar(&rules.PointsToWin, "points", defaultRules.PointsToWin, "points needed to win a game")
+	fs.BoolVar(&rules.WinByTwo, "win-by-two", defaultRules.WinByTwo, "require a two point lead to win a game")
+	fs.IntVar(&rules.BestOf, "best-of", defaultRules.BestOf, "number of games in a match")
+	fs.BoolVar(&rules.SwitchSides, "switch-sides", defaultRules.SwitchSides, "switch ends after each game")
+	physicsPath := fs.String("physics", "", "JSON file with table and physics settings")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *physicsPath != "" {
+		config, err := loadPhysicsConfig(*physicsPath)
+		if err != nil {
+			return err
+		}
+		defaultPhysics = config
+	}
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	if *matches <= 0 {
+		return errors.New("-n must be positive")
+	}
+	if *workers <= 0 {
+		return errors.New("-workers must be positive")
+	}
+
+	left, err := aiSpec(*leftSpec)
+	if err != nil {
+		return err
+	}
+	right, err := aiSpec(*rightSpec)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	results := make([]matchResult, *matches)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range *workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = simulateMatch(*seed+int64(i), left, right, rules, *maxDuration)
+			}
+		}()
+	}
+	for i := range *matches {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := summarize(results)
+	fmt.Printf("Matches:         %d (left %s vs right %s, seed %d)\n", summary.matches, left, right, *seed)
+	fmt.Printf("Left wins:       %d (%.1f%%)\n", summary.leftWins, percent(summary.leftWins, summary.matches))
+	fmt.Printf("Right wins:      %d (%.1f%%)\n", summary.rightWins, percent(summary.rightWins, summary.matches))
+	fmt.Printf("Unfinished:      %d\n", summary.unfinished)
+	fmt.Printf("Points played:   %d\n", summary.points)
+	if summary.points > 0 {
+		fmt.Printf("Avg rally:       %.2f hits\n", float64(summary.rallyHits)/float64(summary.points))
+		fmt.Printf("Point duration:  avg %s, p50 %s, p90 %s, max %s\n",
+			averageDuration(summary.pointDurations).Round(time.Millisecond),
+			percentile(summary.pointDurations, 50).Round(time.Millisecond),
+			percentile(summary.pointDurations, 90).Round(time.Millisecond),
+			percentile(summary.pointDurations, 100).Round(time.Millisecond))
+	}
+	fmt.Printf("Elapsed:         %s\n", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+func aiSpec(value string) (string, error) {
+	spec := value
+	if !strings.HasPrefix(spec, controllerAI+":") {
+		spec = controllerAI + ":" + value
+	}
+	if _, err := parseController(spec); err != nil {
+		return "", err
+	}
+	return spec, nil
+}
+
+func simulateMatch(seed int64, leftSpec, rightSpec string, rules MatchRules, maxDuration time.Duration) matchResult {
+	left, _ := parseController(leftSpec)
+	right, _ := parseController(rightSpec)
+
+	g := newSeededGame(seed)
+	g.setControllers(left, right)
+	g.Rules = rules
+	g.InMenu = false
+	g.resetGame()
+
+	dt := physicsStep.Seconds()
+	maxSteps := int(maxDuration / physicsStep)
+	var result matchResult
+	lastPoint := 0
+	for step := 1; step <= maxSteps && !g.GameOver; step++ {
+		g.update(dt)
+		for _, event := range g.takeEvents() {
+			switch event.Type {
+			case eventPoint:
+				result.points++
+				result.rallyHits += event.Rally
+				result.pointDurations = append(result.pointDurations, time.Duration(step-lastPoint)*physicsStep)
+				lastPoint = step
+			case eventGameOver:
+				// Report the winner by the side it started on.
+				result.winner = event.Scorer
+				if g.SidesSwitched {
+					result.winner = opponent(event.Scorer)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func summarize(results []matchResult) simulationSummary {
+	summary := simulationSummary{matches: len(results)}
+	for _, result := range results {
+		switch result.winner {
+		case "left":
+			summary.leftWins++
+		case "right":
+			summary.rightWins++
+		default:
+			summary.unfinished++
+		}
+		summary.points += result.points
+		summary.rallyHits += result.rallyHits
+		summary.pointDurations = append(summary.pointDurations, result.pointDurations...)
+	}
+	slices.Sort(summary.pointDurations)
+	return summary
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+func averageDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return total / time.Duration(len(durations))
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := (len(sorted) - 1) * p / 100
+	return sorted[index]
+}
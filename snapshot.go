@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	defaultSnapshotPath = "snapshot.json"
+	shutdownTimeout     = 10 * time.Second
+)
+
+// streams is the base context of every request. shutdown cancels it so the
+// /events, /ws and /bot handlers, which would otherwise run until their
+// client leaves, let the server stop.
+var streams, stopStreams = context.WithCancel(context.Background())
+
+// countingSource wraps the game's random source and counts how many values
+// have been drawn, so a restored game can fast-forward a freshly seeded
+// source to exactly where the snapshot left off.
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+func newCountingSource(seed int64, draws uint64) *countingSource {
+	s := &countingSource{src: rand.NewSource(seed).(rand.Source64)}
+	for range draws {
+		s.Int63()
+	}
+	return s
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+func (g *GameState) seedRNG(draws uint64) {
+	g.source = newCountingSource(g.Seed, draws)
+	g.rng = rand.New(g.source)
+}
+
+type controllerSnapshot struct {
+	Spec        string  `json:"spec"`
+	Token       string  `json:"token,omitempty"`
+	TargetY     float64 `json:"targetY,omitempty"`
+	ThinkTimer  float64 `json:"thinkTimer,omitempty"`
+	ErrorOffset float64 `json:"errorOffset,omitempty"`
+	Approaching bool    `json:"approaching,omitempty"`
+	Claimed     bool    `json:"claimed,omitempty"`
+}
+
+func snapshotController(c Controller) controllerSnapshot {
+	s := controllerSnapshot{Spec: c.Spec()}
+	switch c := c.(type) {
+	case *aiController:
+		s.TargetY, s.ThinkTimer = c.targetY, c.thinkTimer
+		s.ErrorOffset, s.Approaching = c.errorOffset, c.approaching
+	case *botController:
+		s.Token = c.token
+	case *onlineController:
+		s.Token, s.Claimed = c.token, c.claimed
+	}
+	return s
+}
+
+func restoreController(s controllerSnapshot) (Controller, error) {
+	c, err := parseController(s.Spec)
+	if err != nil {
+		return nil, err
+	}
+	switch c := c.(type) {
+	case *aiController:
+		c.targetY, c.thinkTimer = s.TargetY, s.ThinkTimer
+		c.errorOffset, c.approaching = s.ErrorOffset, s.Approaching
+	case *botController:
+		c.token = s.Token
+	case *onlineController:
+		c.token, c.claimed = s.Token, s.Claimed
+	}
+	return c, nil
+}
+
+// roomSnapshot is everything needed to carry a room across a restart. The
+// match recording travels with it so the match stays replayable.
+type roomSnapshot struct {
+	ID        string             `json:"id"`
+	JoinCode  string             `json:"joinCode,omitempty"`
+	Game      *GameState         `json:"game"`
+	RNGDraws  uint64             `json:"rngDraws"`
+	Left      controllerSnapshot `json:"left"`
+	Right     controllerSnapshot `json:"right"`
+	Recording *Recording         `json:"recording,omitempty"`
+}
+
+func (r *Room) snapshot() (json.RawMessage, error) {
+	g := r.Game
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return json.Marshal(roomSnapshot{
+		ID:        r.ID,
+		JoinCode:  g.JoinCode,
+		Game:      g,
+		RNGDraws:  g.source.draws,
+		Left:      snapshotController(g.left),
+		Right:     snapshotController(g.right),
+		Recording: g.recording,
+	})
+}
+
+func (s roomSnapshot) restore() (*Room, error) {
+	left, err := restoreController(s.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := restoreController(s.Right)
+	if err != nil {
+		return nil, err
+	}
+	g := s.Game
+	g.left, g.right = left, right
+	g.recordMatches = true
+	g.recording = s.Recording
+	g.JoinCode = s.JoinCode
+	g.Viewers = 0
+	g.seedRNG(s.RNGDraws)
+	// Online players lost their connections with the restart and get the
+	// usual time to rejoin.
+	g.updateWaiting(time.Now())
+	return &Room{ID: s.ID, Game: g, lastSeen: time.Now()}, nil
+}
+
+// pauseForShutdown stops a running game. Held keys are released first so
+// the recording shows the paddles stopping before the pause.
+func (g *GameState) pauseForShutdown() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.releasePaddle("left")
+	g.releasePaddle("right")
+	if !g.Paused && !g.GameOver && !g.InMenu {
+		g.Paused = true
+		g.emit(GameEvent{Type: eventPause, Paused: true})
+	}
+}
+
+func saveSnapshot(path string, list []*Room) error {
+	snapshots := make([]json.RawMessage, 0, len(list))
+	for _, room := range list {
+		data, err := room.snapshot()
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, data)
+	}
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+// restoreSnapshot loads rooms saved by the previous shutdown into rr and
+// removes the snapshot so it is only restored once.
+func restoreSnapshot(path string, rr *RoomRegistry) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var snapshots []roomSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	restored := 0
+	for _, s := range snapshots {
+		if s.Game == nil {
+			continue
+		}
+		room, err := s.restore()
+		if err != nil {
+			slog.Error("restoring room", "room", s.ID, "err", err)
+			continue
+		}
+		rr.add(room)
+		restored++
+	}
+	return restored, os.Remove(path)
+}
+
+// shutdown stops the servers after the game loop has been told to stop:
+// no new rooms are created, running games are paused, streams are closed
+// and open requests get shutdownTimeout to complete. Only then are the
+// games saved and finished matches archived, so no request can change a
+// game after its snapshot.
+func shutdown(loopDone <-chan struct{}, snapshotPath string, servers ...*http.Server) {
+	slog.Info("shutting down")
+	rooms.close()
+	<-loopDone
+
+	list := rooms.active()
+	for _, room := range list {
+		room.Game.pauseForShutdown()
+		room.broadcast()
+	}
+
+	stopStreams()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("shutting down server", "addr", server.Addr, "err", err)
+		}
+	}
+
+	// A request served in the meantime may have resumed a game.
+	for _, room := range list {
+		room.Game.pauseForShutdown()
+	}
+	archiving.Wait()
+	if err := saveSnapshot(snapshotPath, list); err != nil {
+		slog.Error("saving snapshot", "path", snapshotPath, "err", err)
+	} else {
+		slog.Info("saved snapshot", "rooms", len(list), "path", snapshotPath)
+	}
+	for _, room := range list {
+		for _, rec := range room.Game.takeArchive() {
+			archiveMatch(rec)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "net/http"
+
+// watchQueryParameter carries a room's watch ID. Spectators reach a room
+// through it instead of the room ID: it opens the read-only endpoints,
+// /state, /ws and /events, and every endpoint that changes the game refuses
+// it.
+const watchQueryParameter = "watch"
+
+func forbidSpectator(w http.ResponseWriter) {
+	http.Error(w, "spectators cannot control the game", http.StatusForbidden)
+}
+
+// spectating reports whether r was made with a watch ID.
+func spectating(r *http.Request) bool {
+	return r.URL.Query().Has(watchQueryParameter)
+}
+
+// viewedRoom looks up the room for a read-only request, which may come from
+// a player or a spectator.
+func viewedRoom(w http.ResponseWriter, r *http.Request) (*Room, bool) {
+	if !spectating(r) {
+		return roomFromRequest(w, r), false
+	}
+	room := rooms.byWatchID(r.URL.Query().Get(watchQueryParameter))
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return nil, true
+	}
+	return room, true
+}
+
+func (g *GameState) addViewers(n int) {
+	g.mu.Lock()
+	g.Viewers += n
+	g.mu.Unlock()
+}
+
+func (g *GameState) viewerCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.Viewers
+}
+
+// watch subscribes a spectator to the room and counts them as a viewer.
+func (r *Room) watch() *roomSubscriber {
+	sub := r.subscribe()
+	r.Game.addViewers(1)
+	return sub
+}
+
+func (r *Room) unwatch(sub *roomSubscriber) {
+	r.unsubscribe(sub)
+	r.Game.addViewers(-1)
+}
+
+// follow subscribes a player, or a spectator when spectating is set, and
+// returns the matching unsubscribe.
+func (r *Room) follow(spectating bool) (*roomSubscriber, func(*roomSubscriber)) {
+	if spectating {
+		return r.watch(), r.unwatch
+	}
+	return r.subscribe(), r.unsubscribe
+}
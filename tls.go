@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultACMECache  = "acme-cache"
+	certCheckInterval = 10 * time.Second
+	acmeRenewBefore   = 30 * 24 * time.Hour
+)
+
+// certReloader serves a manually supplied certificate and picks up new
+// certificate and key files when either changes on disk, so a renewed
+// certificate is used without restarting the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = now
+
+	modTime, err := r.latestModTime()
+	if err != nil || modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	// A half-written pair fails to load; keep serving the old one and try
+	// again on a later handshake.
+	if err := r.load(modTime); err != nil {
+		slog.Error("reloading certificate", "cert", r.certFile, "err", err)
+		return r.cert, nil
+	}
+	slog.Info("reloaded certificate", "cert", r.certFile)
+	return r.cert, nil
+}
+
+// tlsSetup holds what the HTTPS and redirect listeners need. manager is only
+// set when certificates come from ACME.
+type tlsSetup struct {
+	config  *tls.Config
+	manager *autocert.Manager
+}
+
+func newTLSSetup(c Config) (*tlsSetup, error) {
+	if len(c.ACMEDomains) == 0 {
+		reloader, err := newCertReloader(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tlsSetup{config: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}}, nil
+	}
+
+	client := &acme.Client{DirectoryURL: c.ACMEDirectory}
+	if c.ACMECA != "" {
+		// A test CA such as Pebble serves its directory over HTTPS with a
+		// certificate from its own root.
+		pem, err := os.ReadFile(c.ACMECA)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + c.ACMECA)
+		}
+		client.HTTPClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		}}
+	}
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(c.ACMECache),
+		HostPolicy:  autocert.HostWhitelist(c.ACMEDomains...),
+		RenewBefore: acmeRenewBefore,
+		Client:      client,
+		Email:       c.ACMEEmail,
+	}
+	config := manager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return &tlsSetup{config: config, manager: manager}, nil
+}
+
+// redirectServer returns the plain HTTP listener that sends browsers to the
+// HTTPS address. With ACME it also answers http-01 challenges.
+func (t *tlsSetup) redirectServer(addr, httpsAddr string) *http.Server {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if t.manager != nil {
+		handler = t.manager.HTTPHandler(handler)
+	}
+	return &http.Server{Addr: addr, Handler: logRequests(handler), ReadHeaderTimeout: readHeaderTimeout}
+}
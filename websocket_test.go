@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWSAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept key %q, want s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", got)
+	}
+}
+
+func TestUpgradeWebSocketRejectsBadHandshakes(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"post", http.MethodPost, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "a"}, http.StatusMethodNotAllowed},
+		{"no upgrade", http.MethodGet, map[string]string{"Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "a"}, http.StatusBadRequest},
+		{"old version", http.MethodGet, map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "a"}, http.StatusUpgradeRequired},
+		{"no key", http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/ws", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			if _, err := upgradeWebSocket(w, r); err == nil {
+				t.Fatal("upgraded a bad handshake")
+			}
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// clientFrame encodes a frame as a browser sends it, masked, with the
+// payload length in the form lengthForm asks for: 0 for the shortest, or
+// wsPayloadLen16 or wsPayloadLen64.
+func clientFrame(fin bool, opcode byte, payload []byte, lengthForm byte) []byte {
+	first := opcode
+	if fin {
+		first |= wsFinalFrameBit
+	}
+	frame := []byte{first}
+	n := len(payload)
+	switch {
+	case lengthForm == wsPayloadLen64 || (lengthForm == 0 && n > 0xFFFF):
+		frame = append(frame, wsMaskBit|wsPayloadLen64)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	case lengthForm == wsPayloadLen16 || n > wsMaxControlFrame:
+		frame = append(frame, wsMaskBit|wsPayloadLen16)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, wsMaskBit|byte(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+type serverFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+// readServerFrames splits what the server wrote into frames.
+func readServerFrames(t *testing.T, data []byte) []serverFrame {
+	t.Helper()
+	var frames []serverFrame
+	for len(data) > 0 {
+		if len(data) < 2 || data[1]&wsMaskBit != 0 {
+			t.Fatalf("malformed server frame % x", data)
+		}
+		opcode := data[0] & 0x0F
+		length, rest := uint64(data[1]&0x7F), data[2:]
+		switch length {
+		case wsPayloadLen16:
+			length, rest = uint64(binary.BigEndian.Uint16(rest)), rest[2:]
+		case wsPayloadLen64:
+			length, rest = binary.BigEndian.Uint64(rest), rest[8:]
+		}
+		frames = append(frames, serverFrame{opcode, rest[:length]})
+		data = rest[length:]
+	}
+	return frames
+}
+
+// exchange feeds frames to a server connection over a pipe, reads one
+// message from it and returns the message and the frames it wrote back.
+func exchange(t *testing.T, frames [][]byte) ([]byte, []serverFrame, error) {
+	t.Helper()
+	server, client := net.Pipe()
+	c := &wsConn{conn: server, reader: bufio.NewReader(server)}
+
+	go func() {
+		for _, frame := range frames {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	written := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(client)
+		written <- data
+	}()
+
+	message, err := c.readMessage()
+	server.Close()
+	data := <-written
+	client.Close()
+	return message, readServerFrames(t, data), err
+}
+
+func TestReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	closeFrame := func(code uint16) serverFrame {
+		return serverFrame{wsOpClose, binary.BigEndian.AppendUint16(nil, code)}
+	}
+	tests := []struct {
+		name    string
+		frames  [][]byte
+		want    []byte
+		wantErr bool
+		replies []serverFrame
+	}{
+		{
+			name:   "masked text",
+			frames: [][]byte{clientFrame(true, wsOpText, []byte(`{"paddle":"up"}`), 0)},
+			want:   []byte(`{"paddle":"up"}`),
+		},
+		{
+			name:   "16-bit length",
+			frames: [][]byte{clientFrame(true, wsOpText, long, 0)},
+			want:   long,
+		},
+		{
+			name:   "64-bit length",
+			frames: [][]byte{clientFrame(true, wsOpBinary, long, wsPayloadLen64)},
+			want:   long,
+		},
+		{
+			name: "fragmented",
+			frames: [][]byte{
+				clientFrame(false, wsOpText, []byte("hel"), 0),
+				clientFrame(false, wsOpContinuation, []byte("lo "), wsPayloadLen16),
+				clientFrame(true, wsOpContinuation, []byte("world"), 0),
+			},
+			want: []byte("hello world"),
+		},
+		{
+			name: "ping between fragments",
+			frames: [][]byte{
+				clientFrame(false, wsOpText, []byte("hel"), 0),
+				clientFrame(true, wsOpPing, []byte("are you there"), 0),
+				clientFrame(true, wsOpContinuation, []byte("lo"), 0),
+			},
+			want:    []byte("hello"),
+			replies: []serverFrame{{wsOpPong, []byte("are you there")}},
+		},
+		{
+			name: "pong ignored",
+			frames: [][]byte{
+				clientFrame(true, wsOpPong, nil, 0),
+				clientFrame(true, wsOpText, []byte("hi"), 0),
+			},
+			want: []byte("hi"),
+		},
+		{
+			name:    "close",
+			frames:  [][]byte{clientFrame(true, wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal), 0)},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseNormal)},
+		},
+		{
+			name:    "unmasked",
+			frames:  [][]byte{{wsFinalFrameBit | wsOpText, 2, 'h', 'i'}},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseProtocol)},
+		},
+		{
+			name:    "fragmented ping",
+			frames:  [][]byte{clientFrame(false, wsOpPing, []byte("a"), 0)},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseProtocol)},
+		},
+		{
+			name:    "long ping",
+			frames:  [][]byte{clientFrame(true, wsOpPing, bytes.Repeat([]byte("a"), wsMaxControlFrame+1), 0)},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseProtocol)},
+		},
+		{
+			name:    "continuation without start",
+			frames:  [][]byte{clientFrame(true, wsOpContinuation, []byte("a"), 0)},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseProtocol)},
+		},
+		{
+			name: "new message inside fragments",
+			frames: [][]byte{
+				clientFrame(false, wsOpText, []byte("a"), 0),
+				clientFrame(true, wsOpText, []byte("b"), 0),
+			},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseProtocol)},
+		},
+		{
+			name:    "too large",
+			frames:  [][]byte{clientFrame(true, wsOpText, make([]byte, wsMaxMessageSize+1), 0)},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseTooLarge)},
+		},
+		{
+			name:    "unknown opcode",
+			frames:  [][]byte{clientFrame(true, 0x3, []byte("a"), 0)},
+			wantErr: true,
+			replies: []serverFrame{closeFrame(wsCloseProtocol)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, replies, err := exchange(t, tt.frames)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(message, tt.want) {
+				t.Errorf("message %q, want %q", message, tt.want)
+			}
+			if len(replies) != len(tt.replies) {
+				t.Fatalf("server wrote %v, want %v", replies, tt.replies)
+			}
+			for i, reply := range replies {
+				if reply.opcode != tt.replies[i].opcode || !bytes.Equal(reply.payload, tt.replies[i].payload) {
+					t.Errorf("reply %d: opcode %#x payload % x, want opcode %#x payload % x",
+						i, reply.opcode, reply.payload, tt.replies[i].opcode, tt.replies[i].payload)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteFrameLengths(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{5, []byte{wsFinalFrameBit | wsOpText, 5}},
+		{300, []byte{wsFinalFrameBit | wsOpText, wsPayloadLen16, 0x01, 0x2C}},
+		{70000, []byte{wsFinalFrameBit | wsOpText, wsPayloadLen64, 0, 0, 0, 0, 0, 0x01, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		server, client := net.Pipe()
+		c := &wsConn{conn: server, reader: bufio.NewReader(server)}
+		payload := bytes.Repeat([]byte("y"), tt.size)
+		go func() {
+			c.writeText(payload)
+			server.Close()
+		}()
+		data, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, tt.header) || !bytes.Equal(data[len(tt.header):], payload) {
+			t.Errorf("%d bytes: frame starts % x, want header % x and the payload unmasked",
+				tt.size, data[:min(len(data), 12)], tt.header)
+		}
+	}
+}